@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// Types of the records of the access log
+const (
+	accessRecordType = "access"
+	auditRecordType  = "audit"
+)
+
+// Actions of the audit records
+const (
+	auditPut      = "put"
+	auditDelete   = "delete"
+	auditResize   = "resize"
+	auditRollback = "rollback"
+	// A request with an invalid admin token
+	auditDenied = "denied"
+)
+
+// AccessRecord is a record of the access log for a URL looked up
+type AccessRecord struct {
+	Time       string `json:"time"`
+	Type       string `json:"type"`
+	RequestID  string `json:"request_id,omitempty"`
+	Client     string `json:"client"`
+	URL        string `json:"url"`
+	Verdict    string `json:"verdict"`
+	Category   string `json:"category,omitempty"`
+	ThreatType string `json:"threat_type,omitempty"`
+	Rule       string `json:"rule,omitempty"`
+	// Latency of the request in milliseconds, which is the same for all the
+	// URLs of a batch
+	LatencyMs float64 `json:"latency_ms"`
+	// DiskLoad tells if the URL was missed in memory and loaded from the disk
+	DiskLoad bool `json:"disk_load"`
+}
+
+// AuditRecord is a record of the access log for a change of the admin API
+type AuditRecord struct {
+	Time      string      `json:"time"`
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	Client    string      `json:"client"`
+	Action    string      `json:"action"`
+	Record    *URLDBEntry `json:"record,omitempty"`
+	Status    int         `json:"status"`
+}
+
+// rotatingFile is a file that is renamed to <path>.1 when it grows beyond
+// maxSize, shifting the older files up to <path>.<maxBackups>
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	// The last rotation failed
+	rotateFailed bool
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, stat.Size()
+	return nil
+}
+
+// Rotate the file, and open a new one. The file is closed only once the new one
+// is open, so that it's still written to if the rotation fails.
+func (f *rotatingFile) rotate() error {
+	for i := f.maxBackups - 1; i > 0; i-- {
+		backup := fmt.Sprintf("%s.%d", f.path, i)
+		if err := os.Rename(backup, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if f.maxBackups > 0 {
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	file := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Write writes p, rotating the file first if p would grow it beyond maxSize.
+// Callers write a whole record at a time, so that none is split across files.
+// If the file can't be rotated, p is written to it anyway, and it's rotated
+// again with the next record. Only the first failure of a run is logged.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil && !f.rotateFailed {
+			logger.errorf("Failed to rotate %v: %v", f.path, err)
+		}
+		f.rotateFailed = err != nil
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+// accessLog writes the records of the lookups and of the changes of the admin
+// API as JSON lines
+type accessLog struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// openAccessLog opens the access log of the options, or returns nil if it's
+// disabled
+func openAccessLog(opts *logOptions) (*accessLog, error) {
+	switch opts.accessLogFile {
+	case "":
+		return nil, nil
+	case "-":
+		return &accessLog{out: os.Stdout}, nil
+	}
+	if opts.accessLogMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum size of the access log: %v MB", opts.accessLogMaxSize)
+	}
+	file, err := openRotatingFile(opts.accessLogFile, int64(opts.accessLogMaxSize)<<20, opts.accessLogMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{out: file}, nil
+}
+
+func (a *accessLog) write(record interface{}) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.errorf("Failed to marshal an access record: %v", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		logger.errorf("Failed to write the access log: %v", err)
+	}
+}
+
+func (a *accessLog) Close() error {
+	if closer, ok := a.out.(io.Closer); ok && a.out != os.Stdout {
+		return closer.Close()
+	}
+	return nil
+}
+
+// logAccess writes a record of the access log for every URL looked up by a
+// request since start
+func (s *urlLookupServer) logAccess(request *restful.Request, start time.Time, urls []URL, urlinfos []*URLInfo, loaded []bool) {
+	if s.accessLog == nil {
+		return
+	}
+	s.logLookups(s.clientID(request), requestID(request), start, urls, urlinfos, loaded)
+}
+
+// logLookups writes a record of the access log for every URL looked up by a
+// request of a client since start
+func (s *urlLookupServer) logLookups(client, id string, start time.Time, urls []URL, urlinfos []*URLInfo, loaded []bool) {
+	if s.accessLog == nil {
+		return
+	}
+	now := time.Now()
+	latency := float64(now.Sub(start)) / float64(time.Millisecond)
+	for i, url := range urls {
+		info := urlinfos[i]
+		s.accessLog.write(&AccessRecord{
+			Time:       now.UTC().Format(time.RFC3339Nano),
+			Type:       accessRecordType,
+			RequestID:  id,
+			Client:     client,
+			URL:        url.hostAndPort + "/" + url.originalPath,
+			Verdict:    verdictOf(info),
+			Category:   info.Category,
+			ThreatType: info.ThreatType,
+			Rule:       info.Rule,
+			LatencyMs:  latency,
+			DiskLoad:   loaded != nil && loaded[i],
+		})
+	}
+}
+
+// logAudit writes a record of the access log for a change of the admin API
+func (s *urlLookupServer) logAudit(request *restful.Request, action string, record *URLDBEntry, status int) {
+	if s.accessLog == nil {
+		return
+	}
+	s.accessLog.write(&AuditRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Type:      auditRecordType,
+		RequestID: requestID(request),
+		Client:    s.clientID(request),
+		Action:    action,
+		Record:    record,
+		Status:    status,
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that the log writes the records at or above its level
+func TestLeveledLogger(t *testing.T) {
+	var out bytes.Buffer
+	l := &leveledLogger{out: &out, level: levelWarn, json: true}
+	l.debugf("debug")
+	l.infof("info")
+	l.warnf("warn %v\n", 1)
+	l.errorf("error %v", 2)
+
+	records := []LogRecord{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		record := LogRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Errorf("Failed to unmarshal %s: %v\n", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	expected := []LogRecord{{Level: "warn", Msg: "warn 1"}, {Level: "error", Msg: "error 2"}}
+	if len(records) != len(expected) {
+		t.Errorf("Logged %v, expected %v\n", records, expected)
+		return
+	}
+	for i := range records {
+		if records[i].Level != expected[i].Level || records[i].Msg != expected[i].Msg || records[i].Time == "" {
+			t.Errorf("Logged %v, expected %v\n", records[i], expected[i])
+		}
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Errorf("Parsed an unknown log level\n")
+	}
+	if err := l.configure("info", "xml"); err == nil {
+		t.Errorf("Configured an unknown log format\n")
+	}
+}
+
+// Test that the access log is rotated before it grows beyond its size
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	file, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Errorf("Failed to open %v: %v\n", path, err)
+		return
+	}
+	for i := 0; i < 7; i++ {
+		if _, err := fmt.Fprintf(file, "%039d\n", i); err != nil {
+			t.Errorf("Failed to write to %v: %v\n", path, err)
+		}
+	}
+	file.Close()
+
+	// Every file has two records, and the oldest ones are dropped
+	for name, expected := range map[string]string{"access.log": "6", "access.log.1": "4,5", "access.log.2": "2,3"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Failed to read %v: %v\n", name, err)
+			continue
+		}
+		records := []string{}
+		for _, line := range strings.Fields(string(data)) {
+			records = append(records, strings.TrimLeft(line, "0"))
+		}
+		if strings.Join(records, ",") != expected {
+			t.Errorf("%v has records %v, expected %v\n", name, records, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Kept more rotated files than the maximum: %v\n", err)
+	}
+}
+
+// Test that the records are written to the file when it fails to be rotated,
+// and that the backups are not overwritten
+func TestRotatingFileFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	if err := ioutil.WriteFile(path+".1", []byte("backup\n"), 0644); err != nil {
+		t.Errorf("Failed to write %v: %v\n", path+".1", err)
+	}
+	// A directory in the way of the oldest backup fails the renames
+	if err := os.MkdirAll(filepath.Join(path+".2", "blocker"), 0755); err != nil {
+		t.Errorf("Failed to create %v: %v\n", path+".2", err)
+	}
+
+	file, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Errorf("Failed to open %v: %v\n", path, err)
+		return
+	}
+	defer file.Close()
+	for i := 1; i <= 4; i++ {
+		if i == 4 {
+			os.RemoveAll(path + ".2")
+		}
+		if _, err := fmt.Fprintf(file, "%039d\n", i); err != nil {
+			t.Errorf("Failed to write record %v: %v\n", i, err)
+		}
+	}
+
+	// The records written while it failed are rotated with the next one
+	for name, expected := range map[string]string{"access.log": "4", "access.log.1": "1,2,3", "access.log.2": "backup"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Failed to read %v: %v\n", name, err)
+			continue
+		}
+		records := []string{}
+		for _, line := range strings.Fields(string(data)) {
+			records = append(records, strings.TrimLeft(line, "0"))
+		}
+		if strings.Join(records, ",") != expected {
+			t.Errorf("%v has records %v, expected %v\n", name, records, expected)
+		}
+	}
+}
+
+// Test that the lookups and the changes of the admin API are recorded in the
+// access log
+func TestAccessLog(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	// Only one URL is cached, so the other is loaded from the disk
+	store := newBucketStore(urlCachePath, 1, 1)
+	var out bytes.Buffer
+	server := &urlLookupServer{store: store, adminToken: "secret", accessLog: &accessLog{out: &out}}
+	urls := []URL{{"www.cnn.com:80", "news"}, {"www.evil.com:80", "login"}}
+	for i, url := range urls {
+		if err := store.Put(url, &URLInfo{Category: fmt.Sprintf("category%v", i)}); err != nil {
+			t.Errorf("Failed to add %v: %v\n", url, err)
+		}
+	}
+
+	for _, url := range append(urls, URL{"www.unknown.com:80", "index.html"}) {
+		status, body := serveRequest(server, "GET", "/urlinfo/2/"+url.hostAndPort+"/"+url.originalPath, "", "", &URLInfo{})
+		if status != http.StatusOK {
+			t.Errorf("Lookup of %v returned %v: %v\n", url, status, body)
+		}
+	}
+	serveAdmin(server, "PUT", "/admin/v1/urls", `{"host": "www.evil.com", "path": "x", "category": "phishing"}`, "secret", nil)
+	serveAdmin(server, "DELETE", "/admin/v1/urls/www.evil.com/y", "", "wrong", nil)
+
+	type record struct {
+		AccessRecord
+		Action string `json:"action"`
+		Status int    `json:"status"`
+	}
+	records := []record{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		r := record{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Errorf("Failed to unmarshal %s: %v\n", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 5 {
+		t.Errorf("Access log has %v records, expected 5\n", len(records))
+		return
+	}
+	loaded := 0
+	for i, r := range records[:3] {
+		if r.Type != accessRecordType || r.RequestID == "" || r.Client == "" || r.LatencyMs < 0 {
+			t.Errorf("Unexpected access record %v\n", r)
+		}
+		if i < 2 && (r.Verdict != "unsafe" || r.Category != fmt.Sprintf("category%v", i) || r.Rule == "") {
+			t.Errorf("Unexpected access record %v of %v\n", r, urls[i])
+		}
+		if r.DiskLoad {
+			loaded++
+		}
+	}
+	if records[2].Verdict != "unknown" || loaded == 0 {
+		t.Errorf("Unexpected access records %v\n", records[:3])
+	}
+	if r := records[3]; r.Type != auditRecordType || r.Action != auditPut || r.Status != http.StatusOK {
+		t.Errorf("Unexpected audit record of a put %v\n", r)
+	}
+	if r := records[4]; r.Type != auditRecordType || r.Action != auditDenied || r.Status != http.StatusUnauthorized {
+		t.Errorf("Unexpected audit record of a denied delete %v\n", r)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// The query parameter of the admin API for the match of a record
+const matchParameter = "match"
+
+// AdminURLs are the url records added with the admin API, and the URLs and
+// matches of the records of the configuration files deleted with it
+type AdminURLs struct {
+	URLEntries []URLDBEntry `json:"urls"`
+	Deleted    []URLDBEntry `json:"deleted,omitempty"`
+}
+
+// Read the token of the admin API from a file
+func readAdminToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty admin token", path)
+	}
+	return token, nil
+}
+
+// adminFilter lets in the requests with the admin token as a bearer token
+func (s *urlLookupServer) adminFilter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if s.adminToken == "" {
+		writeError(request, response, http.StatusForbidden, errAdminDisabled,
+			fmt.Errorf("the admin API is disabled without an admin token"))
+		return
+	}
+	authorization := request.HeaderParameter("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		response.AddHeader("WWW-Authenticate", `Bearer realm="url-lookup"`)
+		writeError(request, response, http.StatusUnauthorized, errUnauthorized, fmt.Errorf("invalid admin token"))
+		s.logAudit(request, auditDenied, nil, http.StatusUnauthorized)
+		return
+	}
+	chain.ProcessFilter(request, response)
+}
+
+// The key of a record, with its URL canonicalized
+func recordKey(record *URLDBEntry) (entryKey, error) {
+	url, err := canonicalize(URL{hostAndPort: record.HostAndPort, originalPath: record.OriginalPath})
+	if err != nil {
+		return entryKey{}, err
+	}
+	if record.Match != "" && record.Match != matchExact && record.Match != matchPrefix {
+		return entryKey{}, fmt.Errorf("unknown match '%v'", record.Match)
+	}
+	return entryKey{url: url, prefix: record.Match == matchPrefix}, nil
+}
+
+// The record of a key, with only its URL and match
+func keyRecord(key entryKey) URLDBEntry {
+	record := URLDBEntry{HostAndPort: key.url.hostAndPort, OriginalPath: key.url.originalPath}
+	if key.prefix {
+		record.Match = matchPrefix
+	}
+	return record
+}
+
+// addedFile parses the records added with the admin API into the pseudo
+// configuration file that overrides the others, along with the records of the
+// files deleted with it. The records that have expired or that the taxonomy
+// doesn't accept anymore are dropped. Callers hold reloadLock.
+func (s *urlLookupServer) addedFile() *configFile {
+	entries := make(fileEntries)
+	now := expiryNow()
+	for key, record := range s.added {
+		_, info, err := parseEntry(&record, s.taxonomy)
+		if err != nil {
+			logger.warnf("Dropped the added record %v: %v", key, err)
+			delete(s.added, key)
+			continue
+		}
+		if info.expired(now) {
+			delete(s.added, key)
+			purgedTotal.inc()
+			continue
+		}
+		entries[key] = info
+	}
+	for key := range s.deleted {
+		entries[key] = nil
+	}
+	data, _ := json.Marshal(&AdminURLs{URLEntries: s.addedRecords(), Deleted: s.deletedRecords()})
+	return newPinnedFile(fmt.Sprintf("%x", sha256.Sum256(data)), entries)
+}
+
+// The records added with the admin API, sorted by URL. Callers hold
+// reloadLock.
+func (s *urlLookupServer) addedRecords() []URLDBEntry {
+	keys := make([]string, 0, len(s.added))
+	byKey := make(map[string]URLDBEntry)
+	for key, record := range s.added {
+		keys = append(keys, key.String())
+		byKey[key.String()] = record
+	}
+	sort.Strings(keys)
+	records := make([]URLDBEntry, len(keys))
+	for i, key := range keys {
+		records[i] = byKey[key]
+	}
+	return records
+}
+
+// The keys of the records of the files deleted with the admin API, as records
+// sorted by URL. Callers hold reloadLock.
+func (s *urlLookupServer) deletedRecords() []URLDBEntry {
+	keys := make([]string, 0, len(s.deleted))
+	byKey := make(map[string]URLDBEntry)
+	for key := range s.deleted {
+		keys = append(keys, key.String())
+		byKey[key.String()] = keyRecord(key)
+	}
+	sort.Strings(keys)
+	records := make([]URLDBEntry, len(keys))
+	for i, key := range keys {
+		records[i] = byKey[key]
+	}
+	return records
+}
+
+// copyAdmin returns copies of records added and deleted with the admin API
+func copyAdmin(added map[entryKey]URLDBEntry, deleted map[entryKey]bool) (map[entryKey]URLDBEntry, map[entryKey]bool) {
+	addedCopy := make(map[entryKey]URLDBEntry, len(added))
+	for key, record := range added {
+		addedCopy[key] = record
+	}
+	deletedCopy := make(map[entryKey]bool, len(deleted))
+	for key := range deleted {
+		deletedCopy[key] = true
+	}
+	return addedCopy, deletedCopy
+}
+
+// applyChange applies a change of the admin API to the added records. Deleting
+// an added record uncovers the records of the files for the same key, while
+// deleting one that is not added deletes those of the files, until it's put
+// again. Callers hold reloadLock.
+func (s *urlLookupServer) applyChange(key entryKey, record *URLDBEntry) {
+	if s.added == nil {
+		s.added = make(map[entryKey]URLDBEntry)
+	}
+	if s.deleted == nil {
+		s.deleted = make(map[entryKey]bool)
+	}
+	if record != nil {
+		s.added[key] = *record
+		delete(s.deleted, key)
+	} else if _, ok := s.added[key]; ok {
+		delete(s.added, key)
+	} else {
+		s.deleted[key] = true
+	}
+}
+
+// pruneDeleted drops the deletions of the records that none of the files of the
+// current snapshot have anymore. Callers hold reloadLock.
+func (s *urlLookupServer) pruneDeleted() {
+	for key := range s.deleted {
+		if !s.inFiles(key) {
+			delete(s.deleted, key)
+		}
+	}
+}
+
+// inFiles tells if the configuration files of the current snapshot have a
+// record. Callers hold reloadLock.
+func (s *urlLookupServer) inFiles(key entryKey) bool {
+	if s.current == nil {
+		return false
+	}
+	for path, file := range s.current.files {
+		if path != addedRecords && file.has(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// changeAdded puts a record with the admin API, or deletes it if record is
+// nil, swaps in a snapshot with the change, and journals it. The change is
+// undone if it fails. Callers hold reloadLock.
+func (s *urlLookupServer) changeAdded(key entryKey, record *URLDBEntry) error {
+	old, existed := s.added[key]
+	wasDeleted := s.deleted[key]
+	restore := func() {
+		if existed {
+			s.added[key] = old
+		} else {
+			delete(s.added, key)
+		}
+		if wasDeleted {
+			s.deleted[key] = true
+		} else {
+			delete(s.deleted, key)
+		}
+	}
+	op := &JournalOp{Op: journalPut, Time: time.Now().UTC()}
+	if record != nil {
+		op.Record = *record
+	} else {
+		op.Op = journalDelete
+		op.Record = keyRecord(key)
+	}
+	s.applyChange(key, record)
+	if err := s.reload(s.currentFiles()); err != nil {
+		restore()
+		return err
+	}
+	if s.journal == nil {
+		return nil
+	}
+
+	if err := s.journal.append(op); err != nil {
+		logger.errorf("Failed to journal the change of %v: %v", key, err)
+		restore()
+		s.reload(s.currentFiles())
+		return err
+	}
+	if err := s.journal.compact(s.addedRecords(), s.deletedRecords(), false); err != nil {
+		logger.errorf("Failed to compact the journal: %v", err)
+	}
+	return nil
+}
+
+// Add or replace a url record that is not from a configuration file. It
+// overrides the records of the files for the same URL.
+func (s *urlLookupServer) addEntry(entry *URLDBEntry) error {
+	url, _, err := parseEntry(entry, s.taxonomy)
+	if err != nil {
+		return err
+	}
+	record := *entry
+	record.HostAndPort, record.OriginalPath = url.hostAndPort, url.originalPath
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	return s.changeAdded(entryKey{url: url, prefix: entry.Match == matchPrefix}, &record)
+}
+
+// Delete a url record added with addEntry, or else the records of the
+// configuration files for the same URL, until it's added again. It returns
+// false if there is none.
+func (s *urlLookupServer) deleteEntry(key entryKey) (bool, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	if _, ok := s.added[key]; !ok && (s.deleted[key] || !s.inFiles(key)) {
+		return false, nil
+	}
+	return true, s.changeAdded(key, nil)
+}
+
+// replayJournal restores the records added with the admin API from the changes
+// of the journal, and compacts it
+func (s *urlLookupServer) replayJournal(ops []JournalOp) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	s.added = make(map[entryKey]URLDBEntry)
+	s.deleted = make(map[entryKey]bool)
+	for i := range ops {
+		key, err := recordKey(&ops[i].Record)
+		if err != nil {
+			logger.warnf("Skipped change %v of the journal: %v", i, err)
+			continue
+		}
+		switch ops[i].Op {
+		case journalPut:
+			s.applyChange(key, &ops[i].Record)
+		case journalDelete:
+			s.applyChange(key, nil)
+		default:
+			logger.warnf("Skipped change %v of the journal: unknown op '%v'", i, ops[i].Op)
+		}
+	}
+	logger.infof("Restored %v added records and %v deleted ones from the journal", len(s.added), len(s.deleted))
+	return s.journal.compact(s.addedRecords(), s.deletedRecords(), true)
+}
+
+// The key of the record of a request, from its host and path parameters and
+// the match query parameter
+func adminKey(request *restful.Request) (entryKey, error) {
+	return recordKey(adminRecord(request))
+}
+
+// The record of a request with only its URL and match, as they are given
+func adminRecord(request *restful.Request) *URLDBEntry {
+	return &URLDBEntry{
+		HostAndPort:  request.PathParameter(hostNameAndPort),
+		OriginalPath: request.PathParameter(originalPathAndQueryString),
+		Match:        request.QueryParameter(matchParameter),
+	}
+}
+
+func (s *urlLookupServer) listAdded(request *restful.Request, response *restful.Response) {
+	s.reloadLock.Lock()
+	urls := &AdminURLs{URLEntries: s.addedRecords(), Deleted: s.deletedRecords()}
+	s.reloadLock.Unlock()
+	if err := response.WriteEntity(urls); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func (s *urlLookupServer) getAdded(request *restful.Request, response *restful.Response) {
+	key, err := adminKey(request)
+	if err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidURL, err)
+		return
+	}
+	s.reloadLock.Lock()
+	record, ok := s.added[key]
+	s.reloadLock.Unlock()
+	if !ok {
+		writeError(request, response, http.StatusNotFound, errNotFound, fmt.Errorf("no added record for %v", key))
+		return
+	}
+	if err := response.WriteEntity(&record); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func (s *urlLookupServer) putAdded(request *restful.Request, response *restful.Response) {
+	record := &URLDBEntry{}
+	defer func() { s.logAudit(request, auditPut, record, response.StatusCode()) }()
+	if err := request.ReadEntity(record); err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidRequest, err)
+		return
+	}
+	if _, _, err := parseEntry(record, s.taxonomy); err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidRecord, err)
+		return
+	}
+	if err := s.addEntry(record); err != nil {
+		writeError(request, response, http.StatusServiceUnavailable, errStoreUnavailable, err)
+		return
+	}
+	key, _ := recordKey(record)
+	s.reloadLock.Lock()
+	*record = s.added[key]
+	s.reloadLock.Unlock()
+	if err := response.WriteEntity(record); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func (s *urlLookupServer) deleteAdded(request *restful.Request, response *restful.Response) {
+	record := adminRecord(request)
+	defer func() { s.logAudit(request, auditDelete, record, response.StatusCode()) }()
+	key, err := recordKey(record)
+	if err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidURL, err)
+		return
+	}
+	deleted, err := s.deleteEntry(key)
+	if err != nil {
+		writeError(request, response, http.StatusServiceUnavailable, errStoreUnavailable, err)
+		return
+	}
+	if !deleted {
+		writeError(request, response, http.StatusNotFound, errNotFound, fmt.Errorf("no record for %v", key))
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// newAdminService creates the web service of the admin API
+func (s *urlLookupServer) newAdminService() *restful.WebService {
+	ws := &restful.WebService{}
+	ws.Path("/admin/v1").
+		Produces(restful.MIME_JSON).
+		Filter(s.adminFilter)
+	urlRoute := fmt.Sprintf("/urls/{%s}/{%s:*}", hostNameAndPort, originalPathAndQueryString)
+	ws.Route(ws.
+		GET("/urls").
+		To(s.listAdded).
+		Doc("List the url records added with the admin API, and those of the configuration files deleted with it").
+		Writes(AdminURLs{}))
+	ws.Route(ws.
+		PUT("/urls").
+		To(s.putAdded).
+		Doc("Add or replace a url record, which overrides the configuration files").
+		Consumes(restful.MIME_JSON).
+		Reads(URLDBEntry{}).
+		Writes(URLDBEntry{}))
+	ws.Route(ws.
+		GET(urlRoute).
+		To(s.getAdded).
+		Doc("Get a url record added with the admin API").
+		Param(ws.QueryParameter(matchParameter, "Match of the record, 'exact' or 'prefix'").DataType("string")).
+		Writes(URLDBEntry{}))
+	ws.Route(ws.
+		DELETE(urlRoute).
+		To(s.deleteAdded).
+		Doc("Delete a url record added with the admin API, or else those of the configuration files").
+		Param(ws.QueryParameter(matchParameter, "Match of the record, 'exact' or 'prefix'").DataType("string")))
+	ws.Route(ws.
+		PUT("/cache").
+		To(s.resizeCache).
+		Doc("Resize the URL cache, rehashing the cached URLs and the bucket files").
+		Consumes(restful.MIME_JSON).
+		Reads(CacheGeometry{}).
+		Writes(StoreStats{}))
+	ws.Route(ws.
+		POST("/snapshot/rollback").
+		To(s.rollbackSnapshot).
+		Doc("Roll back to the previous snapshot of the configuration files").
+		Writes(SnapshotsInfo{}))
+	return ws
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serve a request of the admin API with a token, and decode the response
+func serveAdmin(server *urlLookupServer, method, path, body, token string, entity interface{}) int {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.newContainer().ServeHTTP(recorder, request)
+	json.Unmarshal(recorder.Body.Bytes(), entity)
+	return recorder.Code
+}
+
+// Start a server with the admin API, and the journal in the cache path
+func startAdminServer(t *testing.T, urlCfgPath, urlCachePath string) *urlLookupServer {
+	journal, ops, err := openJournal(urlCachePath)
+	if err != nil {
+		t.Fatalf("Failed to open the journal: %v\n", err)
+	}
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: newMemStore(), adminToken: "secret", journal: journal}
+	if err := server.replayJournal(ops); err != nil {
+		t.Errorf("Failed to replay the journal: %v\n", err)
+	}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load URLs: %v\n", err)
+	}
+	return server
+}
+
+// Test that the admin API needs the admin token
+func TestAdminAuth(t *testing.T) {
+	server := &urlLookupServer{store: newMemStore()}
+	apiErr := &APIError{}
+	if status := serveAdmin(server, "GET", "/admin/v1/urls", "", "secret", apiErr); status != http.StatusForbidden {
+		t.Errorf("Admin API without a token returned %v, %v\n", status, *apiErr)
+	}
+
+	server.adminToken = "secret"
+	for _, token := range []string{"", "wrong"} {
+		apiErr := &APIError{}
+		if status := serveAdmin(server, "GET", "/admin/v1/urls", "", token, apiErr); status != http.StatusUnauthorized ||
+			apiErr.Code != errUnauthorized {
+			t.Errorf("Admin API with token '%v' returned %v, %v\n", token, status, *apiErr)
+		}
+	}
+	if status := serveAdmin(server, "GET", "/admin/v1/urls", "", "secret", &URLs{}); status != http.StatusOK {
+		t.Errorf("Admin API with the token returned %v\n", status)
+	}
+
+	// The routes that change the server are part of the admin API
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"PUT", "/admin/v1/cache", `{"buckets": 3}`, http.StatusBadRequest, errNotResizable},
+		{"POST", "/admin/v1/snapshot/rollback", "", http.StatusConflict, errNoSnapshot},
+	}
+	for _, test := range tests {
+		apiErr := &APIError{}
+		if status := serveAdmin(server, test.method, test.path, test.body, "", apiErr); status != http.StatusUnauthorized {
+			t.Errorf("%v %v without the token returned %v, %v\n", test.method, test.path, status, *apiErr)
+		}
+		apiErr = &APIError{}
+		if status := serveAdmin(server, test.method, test.path, test.body, "secret", apiErr); status != test.status ||
+			apiErr.Code != test.code {
+			t.Errorf("%v %v returned %v, %v, expected %v, %v\n", test.method, test.path, status, *apiErr, test.status, test.code)
+		}
+	}
+	for _, path := range []string{"/cache", "/snapshot/rollback"} {
+		if status := serveAdmin(server, "POST", path, "", "", &APIError{}); status != http.StatusNotFound &&
+			status != http.StatusMethodNotAllowed {
+			t.Errorf("POST %v outside the admin API returned %v\n", path, status)
+		}
+	}
+}
+
+// Test that the records added with the admin API override the configuration
+// files, that the records of the files can be deleted, and that the changes
+// are restored from the journal after a restart
+func TestAdminURLs(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"),
+		URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)})
+
+	start := func() *urlLookupServer { return startAdminServer(t, urlCfgPath, urlCachePath) }
+	server := start()
+	defer func() { server.journal.Close() }()
+
+	puts := []string{
+		`{"host": "WWW.CNN.COM", "path": "news", "category": "compromised", "safe": false}`,
+		`{"host": "www.evil.com", "path": "*", "category": "malware"}`,
+		`{"host": "www.evil.com", "path": "login", "category": "phishing"}`,
+	}
+	for _, put := range puts {
+		record := &URLDBEntry{}
+		if status := serveAdmin(server, "PUT", "/admin/v1/urls", put, "secret", record); status != http.StatusOK ||
+			!strings.HasSuffix(record.HostAndPort, ":80") {
+			t.Errorf("Put %v returned %v, %v\n", put, status, *record)
+		}
+	}
+	apiErr := &APIError{}
+	if status := serveAdmin(server, "PUT", "/admin/v1/urls", `{"host": "www.evil.com", "path": "x"}`, "secret", apiErr); status != http.StatusBadRequest ||
+		apiErr.Code != errInvalidRecord {
+		t.Errorf("Put a record without a category: %v, %v\n", status, *apiErr)
+	}
+
+	urls := []URL{{"www.cnn.com:80", "news"}, {"www.evil.com:80", "index.html"}, {"www.evil.com:80", "login"}}
+	expected := []string{"compromised", "malware", "phishing"}
+	if categories := lookupCategories(server, urls...); strings.Join(categories, ",") != strings.Join(expected, ",") {
+		t.Errorf("Looked up %v, expected %v\n", categories, expected)
+	}
+
+	// Deleting the added record uncovers the one of the files, which is
+	// then deleted in turn
+	for i, category := range []string{"news", "Unknown"} {
+		if status := serveAdmin(server, "DELETE", "/admin/v1/urls/www.cnn.com/news", "", "secret", nil); status != http.StatusNoContent {
+			t.Errorf("Delete %v returned %v\n", i, status)
+		}
+		if categories := lookupCategories(server, urls[0]); categories[0] != category {
+			t.Errorf("Looked up %v after delete %v, expected %v\n", categories, i, category)
+		}
+	}
+	if status := serveAdmin(server, "DELETE", "/admin/v1/urls/www.cnn.com/news", "", "secret", nil); status != http.StatusNotFound {
+		t.Errorf("Delete again returned %v\n", status)
+	}
+	if status := serveAdmin(server, "DELETE", "/admin/v1/urls/www.unknown.com/news", "", "secret", nil); status != http.StatusNotFound {
+		t.Errorf("Delete of an unknown record returned %v\n", status)
+	}
+	record := &URLDBEntry{}
+	if status := serveAdmin(server, "GET", "/admin/v1/urls/www.evil.com:80/*", "", "secret", record); status != http.StatusOK ||
+		record.Category != "malware" {
+		t.Errorf("Get returned %v, %v\n", status, *record)
+	}
+
+	// Restart with a torn change at the end of the journal
+	server.journal.Close()
+	file, err := os.OpenFile(filepath.Join(urlCachePath, journalFile), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Errorf("Failed to open the journal: %v\n", err)
+		return
+	}
+	file.WriteString(`{"op": "put", "rec`)
+	file.Close()
+	server = start()
+
+	expected = []string{"Unknown", "malware", "phishing"}
+	if categories := lookupCategories(server, urls...); strings.Join(categories, ",") != strings.Join(expected, ",") {
+		t.Errorf("Looked up %v after a restart, expected %v\n", categories, expected)
+	}
+	list := &AdminURLs{}
+	if status := serveAdmin(server, "GET", "/admin/v1/urls", "", "secret", list); status != http.StatusOK ||
+		len(list.URLEntries) != 2 || len(list.Deleted) != 1 || list.Deleted[0].OriginalPath != "news" {
+		t.Errorf("Listed %v, %v after a restart\n", status, *list)
+	}
+	if server.journal.ops != 3 {
+		t.Errorf("Journal has %v changes after the compaction, expected 3\n", server.journal.ops)
+	}
+
+	// The deletion is dropped once no file has the record
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"),
+		URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "sports", Category: "sports", Safe: boolPtr(true)})
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load URLs: %v\n", err)
+	}
+	list = &AdminURLs{}
+	if status := serveAdmin(server, "GET", "/admin/v1/urls", "", "secret", list); status != http.StatusOK || len(list.Deleted) != 0 {
+		t.Errorf("Listed %v, %v once no file has the deleted record\n", status, *list)
+	}
+}
+
+// Test that a rollback after a change of the admin API undoes it, in the
+// records listed and in the journal, and that rolling back again redoes it
+func TestAdminRollback(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"),
+		URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)})
+	server := startAdminServer(t, urlCfgPath, urlCachePath)
+	defer func() { server.journal.Close() }()
+	news := URL{"www.cnn.com:80", "news"}
+
+	// check looks up the URL, and lists the records of the admin API, before
+	// and after a restart, which drops the previous snapshot
+	check := func(step, category string, added, deleted, restarts int) {
+		for restart := 0; restart <= restarts; restart++ {
+			if categories := lookupCategories(server, news); categories[0] != category {
+				t.Errorf("Looked up %v after %v and %v restarts, expected %v\n", categories, step, restart, category)
+			}
+			list := &AdminURLs{}
+			if status := serveAdmin(server, "GET", "/admin/v1/urls", "", "secret", list); status != http.StatusOK ||
+				len(list.URLEntries) != added || len(list.Deleted) != deleted {
+				t.Errorf("Listed %v, %v after %v and %v restarts, expected %v added and %v deleted\n",
+					status, *list, step, restart, added, deleted)
+			}
+			if restart < restarts {
+				server.journal.Close()
+				server = startAdminServer(t, urlCfgPath, urlCachePath)
+			}
+		}
+	}
+	rollback := func() {
+		if status := serveAdmin(server, "POST", "/admin/v1/snapshot/rollback", "", "secret", &SnapshotsInfo{}); status != http.StatusOK {
+			t.Errorf("Rollback returned %v\n", status)
+		}
+	}
+
+	put := `{"host": "www.cnn.com", "path": "news", "category": "compromised"}`
+	if status := serveAdmin(server, "PUT", "/admin/v1/urls", put, "secret", &URLDBEntry{}); status != http.StatusOK {
+		t.Errorf("Put returned %v\n", status)
+	}
+	rollback()
+	check("the rollback of a put", "news", 0, 0, 1)
+
+	if status := serveAdmin(server, "DELETE", "/admin/v1/urls/www.cnn.com/news", "", "secret", nil); status != http.StatusNoContent {
+		t.Errorf("Delete returned %v\n", status)
+	}
+	rollback()
+	check("the rollback of a delete", "news", 0, 0, 0)
+	rollback()
+	check("the rollback of the rollback", "Unknown", 0, 1, 1)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/fsnotify/fsnotify"
+)
+
+// The header of the API keys
+const apiKeyHeader = "X-API-Key"
+
+// The routes that the probes of kubelet call without credentials
+var unauthenticatedRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// authOptions defines the TLS and authentication settings of the server
+type authOptions struct {
+	// Certificate and key of the server, which serves plain http without them
+	certFile string
+	keyFile  string
+	// CA bundle that the client certificates are verified against, which
+	// are required if set
+	clientCAFile string
+	// File of the API keys, one per line, which are required if set
+	apiKeysFile string
+	// File of the bearer token of the admin API
+	adminTokenFile string
+}
+
+// newTLSConfig returns the TLS configuration of the server, or nil to serve
+// plain http. Client certificates are verified if given, and the auth filter
+// requires them on every route but the probes.
+func newTLSConfig(opts *authOptions) (*tls.Config, error) {
+	if opts.certFile == "" && opts.keyFile == "" {
+		if opts.clientCAFile != "" {
+			return nil, fmt.Errorf("client certificates need a server certificate")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if opts.clientCAFile != "" {
+		data, err := ioutil.ReadFile(opts.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates", opts.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// apiKeys are the API keys of a file, which is reloaded when it changes. Only
+// the digests of the keys are kept.
+type apiKeys struct {
+	path    string
+	lock    sync.RWMutex
+	digests map[[sha256.Size]byte]bool
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// loadAPIKeys reads the API keys of a file
+func loadAPIKeys(path string) (*apiKeys, error) {
+	keys := &apiKeys{path: filepath.Clean(path)}
+	if err := keys.reload(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// reload reads the API keys again. Blank lines and lines starting with # are
+// skipped. The current keys are kept if the file can't be read.
+func (k *apiKeys) reload() error {
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		logger.errorf("Failed to read the API keys: %v", err)
+		return err
+	}
+	digests := make(map[[sha256.Size]byte]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		digests[sha256.Sum256([]byte(key))] = true
+	}
+
+	k.lock.Lock()
+	k.digests = digests
+	k.lock.Unlock()
+	logger.infof("Read %v API keys from %s", len(digests), k.path)
+	return nil
+}
+
+// valid tells if a key is one of the API keys
+func (k *apiKeys) valid(key string) bool {
+	digest := sha256.Sum256([]byte(key))
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.digests[digest]
+}
+
+// watch reloads the API keys when their file is written or replaced, or when
+// the ConfigMap or Secret volume it's in is updated
+func (k *apiKeys) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	k.watcher, k.done = watcher, make(chan struct{})
+	go func() {
+		defer close(k.done)
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(event.Name)
+				if name != filepath.Base(k.path) && name != configMapDataDir {
+					continue
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+					k.reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.errorf("Failed to watch the API keys: %v", err)
+			}
+		}
+	}()
+
+	if err := watcher.Add(filepath.Dir(k.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	return nil
+}
+
+// Stop the watcher, and wait for its go routine to exit
+func (k *apiKeys) stopWatching() {
+	if k.watcher == nil {
+		return
+	}
+	if err := k.watcher.Close(); err != nil {
+		logger.errorf("Failed to stop the watcher of the API keys: %v", err)
+	}
+	<-k.done
+}
+
+// authFilter lets in the requests with a verified client certificate, if they
+// are required, and with one of the API keys, if any. The probes are let in
+// without them.
+func (s *urlLookupServer) authFilter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if unauthenticatedRoutes[request.Request.URL.Path] {
+		chain.ProcessFilter(request, response)
+		return
+	}
+	if s.requireClientCert {
+		if state := request.Request.TLS; state == nil || len(state.VerifiedChains) == 0 {
+			writeError(request, response, http.StatusUnauthorized, errUnauthorized,
+				fmt.Errorf("a client certificate is required"))
+			return
+		}
+	}
+	if s.apiKeys != nil && !s.apiKeys.valid(request.HeaderParameter(apiKeyHeader)) {
+		writeError(request, response, http.StatusUnauthorized, errUnauthorized, fmt.Errorf("invalid API key"))
+		return
+	}
+	chain.ProcessFilter(request, response)
+}
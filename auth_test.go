@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serve a GET request with an API key, and return the status
+func getWithAPIKey(server *urlLookupServer, path, key string) int {
+	request := httptest.NewRequest("GET", path, nil)
+	if key != "" {
+		request.Header.Set(apiKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	server.newContainer().ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+// Test that the API keys are required but by the probes, and reloaded when
+// their file changes
+func TestAPIKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+	if err := ioutil.WriteFile(path, []byte("key1\n# retired: key0\n  key2  \n"), 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", path, err)
+	}
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Errorf("Failed to load the API keys: %v\n", err)
+		return
+	}
+	if err := keys.watch(); err != nil {
+		t.Errorf("Failed to watch the API keys: %v\n", err)
+	}
+	defer keys.stopWatching()
+
+	server := &urlLookupServer{store: newMemStore(), apiKeys: keys}
+	lookup := "/urlinfo/1/www.cnn.com:80/news"
+	tests := []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{lookup, "key1", http.StatusOK},
+		{lookup, "key2", http.StatusOK},
+		{lookup, "key0", http.StatusUnauthorized},
+		{lookup, "", http.StatusUnauthorized},
+		{"/cache", "", http.StatusUnauthorized},
+		{"/readyz", "", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		if status := getWithAPIKey(server, test.path, test.key); status != test.status {
+			t.Errorf("GET %v with key '%v' returned %v, expected %v\n", test.path, test.key, status, test.status)
+		}
+	}
+
+	// Replace the file, as kubelet does for a Secret volume
+	next := filepath.Join(dir, ".keys")
+	if err := ioutil.WriteFile(next, []byte("key3\n"), 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", next, err)
+	}
+	if err := os.Rename(next, path); err != nil {
+		t.Errorf("Failed to rename %v: %v\n", next, err)
+	}
+	for i := 0; i < 50 && !keys.valid("key3"); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if status := getWithAPIKey(server, lookup, "key3"); status != http.StatusOK {
+		t.Errorf("New key returned %v after the reload\n", status)
+	}
+	if status := getWithAPIKey(server, lookup, "key1"); status != http.StatusUnauthorized {
+		t.Errorf("Removed key returned %v after the reload\n", status)
+	}
+}
+
+// Create a certificate signed by a parent, or self-signed if parent is nil,
+// and write it and its key to files in dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v\n", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create a certificate: %v\n", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0666)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0666)
+	return cert, key
+}
+
+// Test that the server verifies the client certificates against the CA bundle,
+// and requires them but for the probes
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	writeCert(t, dir, "other", nil, nil)
+
+	opts := &authOptions{
+		certFile:     filepath.Join(dir, "server.crt"),
+		keyFile:      filepath.Join(dir, "server.key"),
+		clientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	config, err := newTLSConfig(opts)
+	if err != nil {
+		t.Errorf("Failed to create the TLS config: %v\n", err)
+		return
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Failed to listen: %v\n", err)
+		return
+	}
+	server := &urlLookupServer{store: newMemStore(), requireClientCert: true}
+	stop := make(chan struct{})
+	done := server.serve(tls.NewListener(listener, config), server.newContainer(), time.Second, stop)
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(path, client string) (int, error) {
+		config := &tls.Config{RootCAs: roots}
+		if client != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, client+".crt"), filepath.Join(dir, client+".key"))
+			if err != nil {
+				return 0, err
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		response, err := httpClient.Get(fmt.Sprintf("https://%v%v", listener.Addr(), path))
+		if err != nil {
+			return 0, err
+		}
+		response.Body.Close()
+		return response.StatusCode, nil
+	}
+
+	lookup := "/urlinfo/1/www.cnn.com:80/news"
+	tests := []struct {
+		path   string
+		client string
+		status int
+	}{
+		{lookup, "client", http.StatusOK},
+		{lookup, "", http.StatusUnauthorized},
+		{"/readyz", "", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		if status, err := get(test.path, test.client); err != nil || status != test.status {
+			t.Errorf("GET %v with certificate '%v' returned %v, %v, expected %v\n", test.path, test.client, status, err, test.status)
+		}
+	}
+	// The client doesn't send a certificate that the server's CAs didn't issue
+	if status, err := get(lookup, "other"); err == nil && status != http.StatusUnauthorized {
+		t.Errorf("GET with a certificate of another CA returned %v\n", status)
+	}
+
+	if _, err := newTLSConfig(&authOptions{clientCAFile: opts.clientCAFile}); err == nil {
+		t.Errorf("Created a TLS config with client certificates but no server certificate\n")
+	}
+}
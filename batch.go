@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+const maxBatchSize = 1000
+
+// URLQuery defines a URL to look up in a batch, given either as host and path
+// or as a full URL
+type URLQuery struct {
+	HostAndPort  string `json:"host,omitempty"`
+	OriginalPath string `json:"path,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+func (q *URLQuery) toURL() (*URL, error) {
+	if q.URL != "" {
+		return parseURL(q.URL)
+	}
+	if q.HostAndPort == "" {
+		return nil, fmt.Errorf("either url or host is required")
+	}
+	url, err := canonicalize(URL{hostAndPort: q.HostAndPort, originalPath: q.OriginalPath})
+	if err != nil {
+		return nil, err
+	}
+	return &url, nil
+}
+
+// lookupURLs returns the route function of the batch lookups for a version of
+// the API
+func (s *urlLookupServer) lookupURLs(view infoView) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		start := time.Now()
+		var queries []URLQuery
+		if err := request.ReadEntity(&queries); err != nil {
+			writeError(request, response, http.StatusBadRequest, errInvalidRequest, err)
+			return
+		}
+		if len(queries) > maxBatchSize {
+			writeError(request, response, http.StatusBadRequest, errBatchTooLarge,
+				fmt.Errorf("batch of %v urls exceeds the maximum of %v", len(queries), maxBatchSize))
+			return
+		}
+
+		urls := make([]URL, len(queries))
+		for i := range queries {
+			url, err := queries[i].toURL()
+			if err != nil {
+				writeError(request, response, http.StatusBadRequest, errInvalidURL, fmt.Errorf("url %v: %v", i, err))
+				return
+			}
+			urls[i] = *url
+		}
+
+		urlinfos, loaded, err := s.lookupLoaded(urls)
+		if err != nil {
+			writeError(request, response, http.StatusServiceUnavailable, errStoreUnavailable, err)
+			return
+		}
+		s.logAccess(request, start, urls, urlinfos, loaded)
+		views := make([]interface{}, len(urlinfos))
+		for i := range urlinfos {
+			views[i] = view(urlinfos[i])
+		}
+		if err := response.WriteEntity(views); err != nil {
+			logger.warnf("Failed to write entry: %v", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		url    URL
+	}{
+		{"http://www.cnn.com/news", URL{"www.cnn.com:80", "news"}},
+		{"https://www.cnn.com/news?page=2", URL{"www.cnn.com:443", "news?page=2"}},
+		{"www.cnn.com:8080/news", URL{"www.cnn.com:8080", "news"}},
+	}
+	for _, test := range tests {
+		url, err := parseURL(test.rawurl)
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v\n", test.rawurl, err)
+			continue
+		}
+		if *url != test.url {
+			t.Errorf("Parsed %v into %v, expected %v\n", test.rawurl, *url, test.url)
+		}
+	}
+
+	if _, err := parseURL("ftp://www.cnn.com/news"); err == nil {
+		t.Errorf("Parsing a ftp url should fail\n")
+	}
+}
+
+// Test a batch lookup with vacated buckets
+func TestLookupBatch(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	server := &urlLookupServer{
+		httpPort:     16888,
+		urlCachePath: urlCachePath,
+		store:        newBucketStore(urlCachePath, 5, 3),
+	}
+	all := append(append([]URLDBEntry{}, entries1.URLEntries...), entries2.URLEntries...)
+	for i := range all {
+		if err := server.addEntry(&all[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", all[i], err)
+		}
+	}
+
+	urls := []URL{{"www.unknown.com:80", "index.html"}}
+	for _, entry := range all {
+		urls = append(urls, URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath})
+	}
+	urlinfos, err := server.lookup(urls)
+	if err != nil {
+		t.Errorf("Failed to look up batch: %v\n", err)
+		return
+	}
+	if urlinfos[0] != notFound {
+		t.Errorf("Unexpected info %v for unknown url\n", *urlinfos[0])
+	}
+	for i, entry := range all {
+		info := urlinfos[i+1]
+		if info.Category != entry.Category || info.Safe != *entry.Safe {
+			t.Errorf("Unmatched record %v: %v\n", entry, *info)
+		}
+	}
+}
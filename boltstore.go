@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltBackend  = "bolt"
+	boltFileName = "urls.db"
+)
+
+var (
+	urlsBucket    = []byte("urls")
+	importsBucket = []byte("imports")
+)
+
+// boltStore keeps the URLs in an embedded B+tree key/value store on the disk,
+// and the URLs looked up most recently in memory. The URLs survive restarts.
+type boltStore struct {
+	db   *bolt.DB
+	lock sync.Mutex
+	hot  *lruCache
+	// Hits and misses of the lookups in memory, and URLs evicted from memory
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func newBoltStore(urlCachePath string, maxUrlsCached int) (*boltStore, error) {
+	db, err := bolt.Open(filepath.Join(urlCachePath, boltFileName), 0666, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, importsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{
+		db:  db,
+		hot: newLRUCache(maxUrlsCached),
+	}, nil
+}
+
+// The key of a URL. Canonical hosts don't have '/'.
+func boltKey(url URL) []byte {
+	return []byte(url.hostAndPort + "/" + url.originalPath)
+}
+
+func boltURL(key []byte) (URL, error) {
+	parts := strings.SplitN(string(key), "/", 2)
+	if len(parts) != 2 {
+		return URL{}, fmt.Errorf("invalid key '%s'", key)
+	}
+	return URL{hostAndPort: parts[0], originalPath: parts[1]}, nil
+}
+
+// Get looks up URLs in memory first, and then reads the missed ones from the disk
+func (s *boltStore) Get(urls []URL) ([]*URLInfo, error) {
+	urlinfos, _, err := s.GetLoaded(urls)
+	return urlinfos, err
+}
+
+// GetLoaded is Get that also tells which URLs were read from the disk
+func (s *boltStore) GetLoaded(urls []URL) ([]*URLInfo, []bool, error) {
+	urlinfos := make([]*URLInfo, len(urls))
+	loaded := make([]bool, len(urls))
+	missed := []int{}
+	s.lock.Lock()
+	for i, url := range urls {
+		info, ok := s.hot.get(url)
+		if !ok {
+			missed = append(missed, i)
+			s.misses++
+			continue
+		}
+		urlinfos[i] = info
+		s.hits++
+	}
+	s.lock.Unlock()
+	if len(missed) == 0 {
+		return urlinfos, loaded, nil
+	}
+
+	start := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(urlsBucket)
+		for _, i := range missed {
+			data := b.Get(boltKey(urls[i]))
+			if data == nil {
+				continue
+			}
+			info := &URLInfo{}
+			if err := json.Unmarshal(data, info); err != nil {
+				return err
+			}
+			urlinfos[i] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	diskLoadSeconds.since(start, boltBackend)
+
+	s.lock.Lock()
+	for _, i := range missed {
+		loaded[i] = true
+		if urlinfos[i] != nil && s.hot.add(urls[i], urlinfos[i]) != nil {
+			s.evictions++
+		}
+	}
+	s.lock.Unlock()
+	return urlinfos, loaded, nil
+}
+
+// Put adds or replaces a URL on the disk
+func (s *boltStore) Put(url URL, info *URLInfo) error {
+	return s.PutAll(URLDB{url: info})
+}
+
+// PutAll adds or replaces URLs on the disk in a single transaction
+func (s *boltStore) PutAll(urldb URLDB) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(urlsBucket)
+		for url, info := range urldb {
+			data, err := json.Marshal(&URLInfo{Category: info.Category, Categories: info.Categories, Safe: info.Safe,
+				Verdict: info.Verdict})
+			if err != nil {
+				return err
+			}
+			if err := b.Put(boltKey(url), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Drop the stale copies in memory
+	s.lock.Lock()
+	for url := range urldb {
+		s.hot.remove(url)
+	}
+	s.lock.Unlock()
+	return err
+}
+
+// Delete removes a URL from the disk and memory
+func (s *boltStore) Delete(url URL) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(urlsBucket).Delete(boltKey(url))
+	})
+	s.lock.Lock()
+	s.hot.remove(url)
+	s.lock.Unlock()
+	return err
+}
+
+// Iterate calls fn with every URL on the disk. fn must not modify the store.
+func (s *boltStore) Iterate(fn func(url URL, info *URLInfo) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(urlsBucket).ForEach(func(k, v []byte) error {
+			url, err := boltURL(k)
+			if err != nil {
+				return err
+			}
+			info := &URLInfo{}
+			if err := json.Unmarshal(v, info); err != nil {
+				return err
+			}
+			return fn(url, info)
+		})
+	})
+}
+
+// Stats returns the number of URLs in memory
+func (s *boltStore) Stats() *StoreStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return &StoreStats{
+		Backend:   boltBackend,
+		Cached:    s.hot.len(),
+		Capacity:  s.hot.capacity,
+		Hits:      s.hits,
+		Misses:    s.misses,
+		Evictions: s.evictions,
+	}
+}
+
+// importRecord is the digest of an imported configuration file, and the keys
+// of the URLs imported from it
+type importRecord struct {
+	Digest string   `json:"digest"`
+	Keys   []string `json:"keys"`
+}
+
+// Imported returns the digest of an imported configuration file and the URLs
+// imported from it. The digest is empty if the file has not been imported.
+func (s *boltStore) Imported(path string) (string, []URL) {
+	record := &importRecord{}
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(importsBucket).Get([]byte(path)); v != nil {
+			return json.Unmarshal(v, record)
+		}
+		return nil
+	})
+
+	urls := make([]URL, 0, len(record.Keys))
+	for _, key := range record.Keys {
+		if url, err := boltURL([]byte(key)); err == nil {
+			urls = append(urls, url)
+		}
+	}
+	return record.Digest, urls
+}
+
+// SetImported records the digest of an imported configuration file and the
+// URLs imported from it
+func (s *boltStore) SetImported(path, digest string, urls []URL) error {
+	record := &importRecord{Digest: digest, Keys: make([]string, len(urls))}
+	for i, url := range urls {
+		record.Keys[i] = string(boltKey(url))
+	}
+	v, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(importsBucket).Put([]byte(path), v)
+	})
+}
+
+// ForgetImported removes the record of an imported configuration file
+func (s *boltStore) ForgetImported(path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(importsBucket).Delete([]byte(path))
+	})
+}
+
+// ImportedFiles returns the paths of the imported configuration files
+func (s *boltStore) ImportedFiles() []string {
+	paths := []string{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(importsBucket).ForEach(func(k, v []byte) error {
+			paths = append(paths, string(k))
+			return nil
+		})
+	})
+	return paths
+}
+
+// Close closes the database file
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
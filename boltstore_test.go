@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that the URLs survive a restart without importing the configuration again
+func TestBoltStoreRestart(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	entries := &URLs{
+		URLEntries: append([]URLDBEntry{
+			{HostAndPort: "*.evil.com:80", OriginalPath: "*", Category: "malware", Safe: boolPtr(false)},
+		}, entries1.URLEntries...),
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Errorf("Failed to Marshall: %v\n", err)
+		return
+	}
+	tmpfn := filepath.Join(urlCfgPath, "urlcfg1.json")
+	if err := ioutil.WriteFile(tmpfn, data, 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", tmpfn, err)
+	}
+
+	for run := 0; run < 2; run++ {
+		store, err := newBoltStore(urlCachePath, 2)
+		if err != nil {
+			t.Errorf("Failed to open bolt store: %v\n", err)
+			return
+		}
+		server := &urlLookupServer{
+			httpPort:     16888,
+			urlCfgPath:   urlCfgPath,
+			urlCachePath: urlCachePath,
+			store:        store,
+		}
+
+		if digest, _ := store.Imported(tmpfn); (digest != "") != (run > 0) {
+			t.Errorf("Unexpected import digest '%v' of %v in run %v\n", digest, tmpfn, run)
+		}
+		if err := server.loadURLs(); err != nil {
+			t.Errorf("Failed to load url Config: %v\n", err)
+		}
+		if run == 1 {
+			// Replacing a URL in the store shows the file is not imported again
+			url := URL{hostAndPort: "www.cnn.com:80", originalPath: "news"}
+			if err := store.Put(url, &URLInfo{Category: "replaced", Safe: true}); err != nil {
+				t.Errorf("Failed to put %v: %v\n", url, err)
+			}
+			if err := server.loadURLs(); err != nil {
+				t.Errorf("Failed to load url Config: %v\n", err)
+			}
+			if infos, _ := server.lookup([]URL{url}); infos[0].Category != "replaced" {
+				t.Errorf("%v has been imported again\n", tmpfn)
+			}
+		}
+
+		urls := []URL{{"www.terror.com:80", "bomb-recipes"}, {"www.food.com:80", "recipes"}, {"a.evil.com:80", "x"}}
+		infos, err := server.lookup(urls)
+		if err != nil {
+			t.Errorf("Failed to look up: %v\n", err)
+		} else if infos[0].Category != "terrorism" || infos[1].Category != "food" || infos[2].Category != "malware" {
+			t.Errorf("Unmatched records after run %v: %v, %v, %v\n", run, *infos[0], *infos[1], *infos[2])
+		}
+		store.Close()
+	}
+
+	// The URLs of a file removed while the server was down are retracted
+	if err := os.Remove(tmpfn); err != nil {
+		t.Errorf("Failed to remove %v: %v\n", tmpfn, err)
+	}
+	store, err := newBoltStore(urlCachePath, 2)
+	if err != nil {
+		t.Errorf("Failed to open bolt store: %v\n", err)
+		return
+	}
+	defer store.Close()
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: urlCachePath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+	infos, err := store.Get([]URL{{"www.terror.com:80", "bomb-recipes"}, {"www.cnn.com:80", "news"}})
+	if err != nil || infos[0] != nil || infos[1] != nil {
+		t.Errorf("URLs of the removed %v are still in the store: %v\n", tmpfn, err)
+	}
+	if files := store.ImportedFiles(); len(files) != 0 {
+		t.Errorf("Removed files are still imported: %v\n", files)
+	}
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	bucketBackend = "bucket"
+	// The file that records the number of buckets of the bucket files
+	geometryFile = "buckets.json"
+	// The directory of the records of the configuration files imported into
+	// the bucket files
+	importsDir = "imports"
+)
+
+// bucket is a file that has the URLs hashed into it
+type bucket struct {
+	lock     sync.Mutex
+	fileName string
+}
+
+// URLHashTbl is a hash table in which each bucket contains a file of URLs
+type URLHashTbl []*bucket
+
+// CacheGeometry defines the number of buckets and the URL capacity of the cache
+type CacheGeometry struct {
+	Buckets  int `json:"buckets"`
+	Capacity int `json:"capacity"`
+}
+
+// bucketStore is a two tier URL store. The persistent tier hashes the URLs into
+// bucket files by host, which are kept across restarts. The memory tier caches the URLs used the most, and
+// evicts them one by one with a W-TinyLFU policy. New URLs are written to the
+// bucket files when they are evicted.
+//
+// A bucket lock is held for any access to the bucket file, and is never held
+// while another bucket is locked. The cache lock is held for any access to the
+// cache, and may be taken while a bucket is locked.
+type bucketStore struct {
+	urlCachePath  string
+	maxUrlsCached int
+	urlht         URLHashTbl
+	lock          sync.Mutex
+	cache         *tinyLFUCache
+	// Evicted dirty entries that are being written to their bucket files
+	pending   map[URL]*cacheEntry
+	hits      int64
+	misses    int64
+	evictions int64
+	spills    int64
+	// Corrupted bucket files rebuilt from the source
+	recoveries int64
+	// The URLs of the configuration files
+	source urlSource
+	// The digests of the configuration files imported into the bucket files
+	imported map[string]string
+	// tblLock protects the geometry of urlht. Lookups and loads hold it
+	// for reading, resize holds it for writing.
+	tblLock sync.RWMutex
+}
+
+func newBucketStore(urlCachePath string, hashTableSize, maxUrlsCached int) *bucketStore {
+	s := &bucketStore{
+		urlCachePath:  urlCachePath,
+		maxUrlsCached: maxUrlsCached,
+		urlht:         newURLHashTbl(hashTableSize, urlCachePath),
+		lock:          sync.Mutex{},
+		cache:         newTinyLFUCache(maxUrlsCached),
+		pending:       make(map[URL]*cacheEntry),
+		imported:      make(map[string]string),
+	}
+	if urlCachePath != "" {
+		s.open()
+	}
+	return s
+}
+
+// open keeps the bucket files of a previous run and the records of the files
+// imported into them, if they have the geometry of the store. Their checksums
+// are verified as they are read, and the corrupted ones are rebuilt from the
+// source. Otherwise they are removed, and the configuration files are all
+// imported again. The temporary files of a write cut short are removed.
+func (s *bucketStore) open() {
+	leftovers, _ := filepath.Glob(filepath.Join(s.urlCachePath, "bucket*.json.*"))
+	tmps, _ := filepath.Glob(filepath.Join(s.urlCachePath, importsDir, "*.tmp*"))
+	for _, file := range append(leftovers, tmps...) {
+		if err := os.Remove(file); err != nil {
+			logger.errorf("Failed to remove %v: %v", file, err)
+		}
+	}
+
+	err := s.readGeometry()
+	if err == nil {
+		err = s.readImports()
+	}
+	if err == nil {
+		return
+	}
+	if !os.IsNotExist(err) {
+		logger.warnf("Discard the bucket files of the previous run: %v", err)
+	}
+	s.imported = make(map[string]string)
+	files, _ := filepath.Glob(filepath.Join(s.urlCachePath, "bucket*.json"))
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			logger.errorf("Failed to remove %v: %v", file, err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(s.urlCachePath, importsDir)); err != nil {
+		logger.errorf("Failed to remove the records of the imports: %v", err)
+	}
+	if err := s.writeGeometry(); err != nil {
+		logger.errorf("Failed to write the geometry of the bucket files: %v", err)
+	}
+}
+
+func hash(s string, size int) int {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int(h.Sum32() % uint32(size))
+}
+
+// The suffix of the bucket files of a resize until they are renamed into place
+const resizeSuffix = ".resize"
+
+func newURLHashTbl(size int, urlCachePath string) URLHashTbl {
+	urlht := make(URLHashTbl, size)
+	for i := 0; i < size; i++ {
+		urlht[i] = &bucket{
+			fileName: fmt.Sprintf("%s/bucket%v.json", urlCachePath, i),
+		}
+	}
+	return urlht
+}
+
+// Save the URLs of a bucket to its file atomically: they are written to a
+// temporary file with a header, synced, and renamed to the bucket file.
+func writeBucketFile(fileName string, urldb URLDB) error {
+	entries := &URLs{
+		URLEntries: make([]URLDBEntry, 0, len(urldb)),
+	}
+	for url, info := range urldb {
+		entries.URLEntries = append(entries.URLEntries, URLDBEntry{
+			HostAndPort:  url.hostAndPort,
+			OriginalPath: url.originalPath,
+			Category:     info.Category,
+			Categories:   info.Categories,
+			Safe:         boolPtr(info.Safe),
+			Verdict:      info.Verdict,
+		})
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logger.errorf("failed to Marshall: %v", err)
+		return err
+	}
+	if err = writeFileAtomic(fileName, append(bucketFileHeader(data), data...)); err != nil {
+		logger.errorf("failed to write %v: %v", fileName, err)
+		return err
+	}
+	return nil
+}
+
+// Read the URLs of a bucket from its file if the file exists. It returns a
+// corruptBucketError if the header or the checksum of the file is wrong.
+func readBucketFile(fileName string, urldb URLDB) error {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	body, err := checkBucketFile(data)
+	if err != nil {
+		return &corruptBucketError{fileName: fileName, err: err}
+	}
+	var urls URLs
+	if err = json.Unmarshal(body, &urls); err != nil {
+		return &corruptBucketError{fileName: fileName, err: err}
+	}
+	for _, entry := range urls.URLEntries {
+		url := URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+		// Entries in memory are newer than the ones in the file
+		if _, ok := urldb[url]; !ok {
+			urldb[url] = &URLInfo{Category: entry.Category, Categories: entry.Categories,
+				Safe: entry.Safe != nil && *entry.Safe, Verdict: entry.Verdict}
+		}
+	}
+	return nil
+}
+
+// Read the URLs of a bucket from its file, and rebuild the file from the
+// source if it's corrupted. The caller must hold the bucket lock, and tblLock.
+func (s *bucketStore) readBucket(bucketNo int, urldb URLDB) error {
+	bucket := s.urlht[bucketNo]
+	err := readBucketFile(bucket.fileName, urldb)
+	if _, corrupted := err.(*corruptBucketError); !corrupted {
+		return err
+	}
+
+	logger.warnf("Rebuild %v from the source: %v", bucket.fileName, err)
+	s.lock.Lock()
+	source := s.source
+	s.recoveries++
+	s.lock.Unlock()
+	rebuilt := make(URLDB)
+	if source != nil {
+		inBucket := func(url URL) bool { return hash(url.hostAndPort, len(s.urlht)) == bucketNo }
+		if rebuilt, err = source(inBucket); err != nil {
+			return err
+		}
+	}
+
+	if err := writeBucketFile(bucket.fileName, rebuilt); err != nil {
+		return err
+	}
+	for url, info := range rebuilt {
+		if _, ok := urldb[url]; !ok {
+			urldb[url] = info
+		}
+	}
+	return nil
+}
+
+// Add and remove URLs in a bucket file. The caller must hold the bucket lock,
+// and tblLock.
+func (s *bucketStore) updateBucket(bucketNo int, added URLDB, removed []URL) error {
+	urldb := make(URLDB)
+	for url, info := range added {
+		urldb[url] = info
+	}
+	if err := s.readBucket(bucketNo, urldb); err != nil {
+		return err
+	}
+	for _, url := range removed {
+		delete(urldb, url)
+	}
+	return writeBucketFile(s.urlht[bucketNo].fileName, urldb)
+}
+
+// SetSource sets the source of the URLs of the configuration files, from which
+// corrupted bucket files are rebuilt
+func (s *bucketStore) SetSource(source urlSource) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.source = source
+}
+
+// Cache a URL, unless ifAbsent is set and it's already cached, and return the
+// entries evicted for it. The dirty ones must then be flushed.
+func (s *bucketStore) admit(url URL, info *URLInfo, dirty, ifAbsent bool) []*cacheEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, cached := s.cache.peek(url); cached && ifAbsent {
+		return nil
+	}
+	evicted := s.cache.add(url, info, dirty)
+	delete(s.pending, url)
+	for _, entry := range evicted {
+		s.evictions++
+		if entry.dirty {
+			s.pending[entry.url] = entry
+		}
+	}
+	return evicted
+}
+
+// Write the dirty evicted entries to the persistent tier. The caller must hold
+// tblLock for reading, and must not hold any bucket lock.
+func (s *bucketStore) flushAll(evicted []*cacheEntry) error {
+	for _, entry := range evicted {
+		if entry.dirty {
+			if err := s.flush(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write an evicted entry to its bucket file, unless it has been replaced or
+// deleted in the meantime
+func (s *bucketStore) flush(entry *cacheEntry) error {
+	bucketNo := hash(entry.url.hostAndPort, len(s.urlht))
+	bucket := s.urlht[bucketNo]
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	s.lock.Lock()
+	current := s.pending[entry.url] == entry
+	s.lock.Unlock()
+	if !current {
+		return nil
+	}
+
+	logger.debugf("Evict url %v to %v", entry.url, bucket.fileName)
+	err := s.updateBucket(bucketNo, URLDB{entry.url: entry.info}, nil)
+	s.lock.Lock()
+	delete(s.pending, entry.url)
+	s.spills++
+	s.lock.Unlock()
+	return err
+}
+
+// Look up URLs that all hash to the given bucket, and tell which were loaded
+// from the disk. The bucket file is read at most once, for the URLs not cached.
+// The caller must hold tblLock for reading.
+func (s *bucketStore) lookupBucket(bucketNo int, urls []URL) ([]*URLInfo, []bool, error) {
+	bucket := s.urlht[bucketNo]
+	urlinfos := make([]*URLInfo, len(urls))
+	loaded := make([]bool, len(urls))
+	missed := []int{}
+
+	bucket.lock.Lock()
+	s.lock.Lock()
+	for i, url := range urls {
+		if entry, ok := s.cache.get(url); ok {
+			urlinfos[i] = entry.info
+			s.hits++
+		} else if entry, ok := s.pending[url]; ok {
+			urlinfos[i] = entry.info
+			s.hits++
+		} else {
+			missed = append(missed, i)
+			s.misses++
+		}
+	}
+	s.lock.Unlock()
+	if len(missed) == 0 {
+		bucket.lock.Unlock()
+		return urlinfos, loaded, nil
+	}
+
+	logger.debugf("Look up %v urls in %v", len(missed), bucket.fileName)
+	start := time.Now()
+	urldb := make(URLDB)
+	if err := s.readBucket(bucketNo, urldb); err != nil {
+		bucket.lock.Unlock()
+		return nil, nil, err
+	}
+	diskLoadSeconds.since(start, bucketBackend)
+	evicted := []*cacheEntry{}
+	for _, i := range missed {
+		urlinfos[i] = urldb[urls[i]]
+		loaded[i] = true
+		if urlinfos[i] == nil {
+			continue
+		}
+		// Cache it unless it has been added since
+		evicted = append(evicted, s.admit(urls[i], urlinfos[i], false, true)...)
+	}
+	bucket.lock.Unlock()
+
+	if err := s.flushAll(evicted); err != nil {
+		return nil, nil, err
+	}
+	return urlinfos, loaded, nil
+}
+
+// Get looks up URLs grouped by bucket, so that each bucket file is read at most
+// once.
+func (s *bucketStore) Get(urls []URL) ([]*URLInfo, error) {
+	urlinfos, _, err := s.GetLoaded(urls)
+	return urlinfos, err
+}
+
+// GetLoaded is Get that also tells which URLs were read from their bucket files
+func (s *bucketStore) GetLoaded(urls []URL) ([]*URLInfo, []bool, error) {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+
+	// Indexes of the URLs in each bucket
+	buckets := make(map[int][]int)
+	for i, url := range urls {
+		bucketNo := hash(url.hostAndPort, len(s.urlht))
+		buckets[bucketNo] = append(buckets[bucketNo], i)
+	}
+
+	urlinfos := make([]*URLInfo, len(urls))
+	loaded := make([]bool, len(urls))
+	for bucketNo, indexes := range buckets {
+		bucketURLs := make([]URL, len(indexes))
+		for i, index := range indexes {
+			bucketURLs[i] = urls[index]
+		}
+		infos, bucketLoaded, err := s.lookupBucket(bucketNo, bucketURLs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, index := range indexes {
+			urlinfos[index] = infos[i]
+			loaded[index] = bucketLoaded[i]
+		}
+	}
+	return urlinfos, loaded, nil
+}
+
+// Put adds or replaces a URL in the cache. It's written to its bucket file when
+// it's evicted.
+func (s *bucketStore) Put(url URL, info *URLInfo) error {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+	logger.debugf("add one url %v", url)
+	return s.flushAll(s.admit(url, info, true, false))
+}
+
+// PutAll adds or replaces URLs directly in their bucket files, writing each file
+// once
+func (s *bucketStore) PutAll(urldb URLDB) error {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+
+	buckets := make(map[int]URLDB)
+	for url, info := range urldb {
+		bucketNo := hash(url.hostAndPort, len(s.urlht))
+		if buckets[bucketNo] == nil {
+			buckets[bucketNo] = make(URLDB)
+		}
+		buckets[bucketNo][url] = info
+	}
+
+	for bucketNo, added := range buckets {
+		bucket := s.urlht[bucketNo]
+		bucket.lock.Lock()
+		// The cached copies are now saved
+		s.lock.Lock()
+		for url, info := range added {
+			if entry, ok := s.cache.peek(url); ok {
+				entry.info = info
+				entry.dirty = false
+			}
+			delete(s.pending, url)
+		}
+		s.lock.Unlock()
+
+		logger.debugf("add %v urls in %v", len(added), bucket.fileName)
+		err := s.updateBucket(bucketNo, added, nil)
+		bucket.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a URL from the cache and its bucket file
+func (s *bucketStore) Delete(url URL) error {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+
+	bucketNo := hash(url.hostAndPort, len(s.urlht))
+	bucket := s.urlht[bucketNo]
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+	s.lock.Lock()
+	s.cache.remove(url)
+	delete(s.pending, url)
+	s.lock.Unlock()
+
+	urldb := make(URLDB)
+	if err := s.readBucket(bucketNo, urldb); err != nil {
+		return err
+	}
+	if _, ok := urldb[url]; !ok {
+		return nil
+	}
+	delete(urldb, url)
+	return writeBucketFile(bucket.fileName, urldb)
+}
+
+// Collect the dirty cached URLs by bucket. The caller must hold tblLock.
+func (s *bucketStore) dirtyURLs() map[int]URLDB {
+	dirty := make(map[int]URLDB)
+	add := func(entry *cacheEntry) {
+		if !entry.dirty {
+			return
+		}
+		bucketNo := hash(entry.url.hostAndPort, len(s.urlht))
+		if dirty[bucketNo] == nil {
+			dirty[bucketNo] = make(URLDB)
+		}
+		dirty[bucketNo][entry.url] = entry.info
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cache.each(add)
+	for _, entry := range s.pending {
+		add(entry)
+	}
+	return dirty
+}
+
+// Iterate calls fn with every URL, both in the cache and in the bucket files
+func (s *bucketStore) Iterate(fn func(url URL, info *URLInfo) error) error {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+
+	dirty := s.dirtyURLs()
+	for bucketNo, bucket := range s.urlht {
+		urldb := make(URLDB)
+		for url, info := range dirty[bucketNo] {
+			urldb[url] = info
+		}
+		bucket.lock.Lock()
+		err := s.readBucket(bucketNo, urldb)
+		bucket.lock.Unlock()
+		if err != nil {
+			return err
+		}
+
+		for url, info := range urldb {
+			if err := fn(url, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of cached URLs, the geometry of the cache and how
+// well the cache is doing
+func (s *bucketStore) Stats() *StoreStats {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return &StoreStats{
+		Backend:    bucketBackend,
+		Cached:     s.cache.len(),
+		Capacity:   s.maxUrlsCached,
+		Buckets:    len(s.urlht),
+		Hits:       s.hits,
+		Misses:     s.misses,
+		Evictions:  s.evictions,
+		Spills:     s.spills,
+		Recoveries: s.recoveries,
+	}
+}
+
+// BucketOccupancy returns the number of URLs cached in each bucket
+func (s *bucketStore) BucketOccupancy() []int {
+	s.tblLock.RLock()
+	defer s.tblLock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	occupancy := make([]int, len(s.urlht))
+	s.cache.each(func(entry *cacheEntry) {
+		occupancy[hash(entry.url.hostAndPort, len(s.urlht))]++
+	})
+	return occupancy
+}
+
+// Close waits for the bucket files being written, and writes the dirty cached
+// URLs to their bucket files, so that the files are complete when the server
+// exits.
+func (s *bucketStore) Close() error {
+	s.tblLock.Lock()
+	defer s.tblLock.Unlock()
+
+	for bucketNo, urldb := range s.dirtyURLs() {
+		if err := s.updateBucket(bucketNo, urldb, nil); err != nil {
+			logger.errorf("Failed to flush %v: %v", s.urlht[bucketNo].fileName, err)
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cache.each(func(entry *cacheEntry) {
+		entry.dirty = false
+	})
+	s.pending = make(map[URL]*cacheEntry)
+	return nil
+}
+
+// Put back the bucket files of the current geometry that a failed resize
+// replaced, from all the URLs, and remove the others. The caller must hold
+// tblLock.
+func (s *bucketStore) restoreBuckets(all URLDB, replaced map[string]bool) {
+	urldbs := make(map[string]URLDB)
+	for _, bucket := range s.urlht {
+		if replaced[bucket.fileName] {
+			urldbs[bucket.fileName] = make(URLDB)
+		}
+	}
+	for url, info := range all {
+		if urldb := urldbs[s.urlht[hash(url.hostAndPort, len(s.urlht))].fileName]; urldb != nil {
+			urldb[url] = info
+		}
+	}
+	for fileName := range replaced {
+		var err error
+		if urldb := urldbs[fileName]; urldb != nil {
+			err = writeBucketFile(fileName, urldb)
+		} else {
+			err = os.Remove(fileName)
+		}
+		if err != nil {
+			logger.errorf("Failed to restore %v: %v", fileName, err)
+		}
+	}
+}
+
+// Resize saves the dirty cached URLs, rehashes all the URLs into bucket files
+// of the given geometry, and starts a new cache with the given capacity. The
+// new bucket files are written before the old ones are replaced, so that a
+// failure leaves the old ones in use.
+func (s *bucketStore) Resize(buckets, capacity int) error {
+	if buckets <= 0 || capacity <= 0 {
+		return fmt.Errorf("invalid cache geometry: %v buckets, %v urls", buckets, capacity)
+	}
+
+	s.tblLock.Lock()
+	defer s.tblLock.Unlock()
+
+	logger.infof("Resize cache from %v buckets and %v urls to %v buckets and %v urls",
+		len(s.urlht), s.maxUrlsCached, buckets, capacity)
+	// Collect all the URLs
+	all := make(URLDB)
+	for _, urldb := range s.dirtyURLs() {
+		for url, info := range urldb {
+			all[url] = info
+		}
+	}
+	for bucketNo, bucket := range s.urlht {
+		if err := s.readBucket(bucketNo, all); err != nil {
+			logger.errorf("Failed to read %v: %v", bucket.fileName, err)
+			return err
+		}
+	}
+
+	// Rehash them into the new buckets
+	rehashed := make([]URLDB, buckets)
+	for i := range rehashed {
+		rehashed[i] = make(URLDB)
+	}
+	for url, info := range all {
+		rehashed[hash(url.hostAndPort, buckets)][url] = info
+	}
+
+	// Write the new bucket files to temporary names, so that the old ones are
+	// left as they are if it fails
+	urlht := newURLHashTbl(buckets, s.urlCachePath)
+	written := []string{}
+	defer func() {
+		for _, fileName := range written {
+			os.Remove(fileName + resizeSuffix)
+		}
+	}()
+	for i, bucket := range urlht {
+		if len(rehashed[i]) == 0 {
+			continue
+		}
+		if err := writeBucketFile(bucket.fileName+resizeSuffix, rehashed[i]); err != nil {
+			return err
+		}
+		written = append(written, bucket.fileName)
+	}
+
+	// Rename them into place, and remove the old files they don't replace
+	// last. The geometry is removed meanwhile, so that the bucket files are
+	// discarded if it's cut short by a crash.
+	geometry := filepath.Join(s.urlCachePath, geometryFile)
+	if err := os.Remove(geometry); err != nil && !os.IsNotExist(err) {
+		logger.errorf("Failed to remove %v: %v", geometry, err)
+		return err
+	}
+	replaced := make(map[string]bool)
+	for _, fileName := range written {
+		if err := os.Rename(fileName+resizeSuffix, fileName); err != nil {
+			logger.errorf("Failed to rename %v: %v", fileName+resizeSuffix, err)
+			s.restoreBuckets(all, replaced)
+			if err := s.writeGeometry(); err != nil {
+				logger.errorf("Failed to write %v: %v", geometry, err)
+			}
+			return err
+		}
+		replaced[fileName] = true
+	}
+	for _, bucket := range s.urlht {
+		if replaced[bucket.fileName] {
+			continue
+		}
+		if err := os.Remove(bucket.fileName); err != nil && !os.IsNotExist(err) {
+			logger.errorf("Failed to remove %v: %v", bucket.fileName, err)
+		}
+	}
+
+	s.lock.Lock()
+	s.urlht = urlht
+	s.maxUrlsCached = capacity
+	s.cache = newTinyLFUCache(capacity)
+	s.pending = make(map[URL]*cacheEntry)
+	s.lock.Unlock()
+	if err := s.writeGeometry(); err != nil {
+		logger.errorf("Failed to write %v: %v", geometry, err)
+	}
+	return nil
+}
+
+// Read the number of buckets of the bucket files, which must be that of the
+// store
+func (s *bucketStore) readGeometry() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.urlCachePath, geometryFile))
+	if err != nil {
+		return err
+	}
+	geometry := &CacheGeometry{}
+	if err := json.Unmarshal(data, geometry); err != nil {
+		return fmt.Errorf("%v: %v", geometryFile, err)
+	}
+	if geometry.Buckets != len(s.urlht) {
+		return fmt.Errorf("%v buckets, expected %v", geometry.Buckets, len(s.urlht))
+	}
+	return nil
+}
+
+// Record the number of buckets of the bucket files
+func (s *bucketStore) writeGeometry() error {
+	data, err := json.Marshal(&CacheGeometry{Buckets: len(s.urlht)})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.urlCachePath, geometryFile), data)
+}
+
+// bucketImport is the record of a configuration file imported into the bucket
+// files
+type bucketImport struct {
+	Path string `json:"path"`
+	importRecord
+}
+
+// The file of the record of an imported configuration file
+func (s *bucketStore) importFile(path string) string {
+	return filepath.Join(s.urlCachePath, importsDir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(path))))
+}
+
+func readImportFile(fileName string) (*bucketImport, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	record := &bucketImport{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return record, nil
+}
+
+// Read the digests of the imported configuration files. The URLs imported from
+// them are read when they are asked for.
+func (s *bucketStore) readImports() error {
+	files, err := filepath.Glob(filepath.Join(s.urlCachePath, importsDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		record, err := readImportFile(file)
+		if err != nil {
+			return err
+		}
+		s.imported[record.Path] = record.Digest
+	}
+	return nil
+}
+
+// Imported returns the digest of an imported configuration file and the URLs
+// imported from it. The digest is empty if the file has not been imported.
+func (s *bucketStore) Imported(path string) (string, []URL) {
+	s.lock.Lock()
+	_, ok := s.imported[path]
+	s.lock.Unlock()
+	if !ok {
+		return "", nil
+	}
+
+	record, err := readImportFile(s.importFile(path))
+	if err != nil {
+		logger.errorf("Failed to read the import of %s: %v", path, err)
+		return "", nil
+	}
+	urls := make([]URL, 0, len(record.Keys))
+	for _, key := range record.Keys {
+		if url, err := boltURL([]byte(key)); err == nil {
+			urls = append(urls, url)
+		}
+	}
+	return record.Digest, urls
+}
+
+// SetImported records the digest of an imported configuration file and the
+// URLs imported from it
+func (s *bucketStore) SetImported(path, digest string, urls []URL) error {
+	record := &bucketImport{Path: path, importRecord: importRecord{Digest: digest, Keys: make([]string, len(urls))}}
+	for i, url := range urls {
+		record.Keys[i] = string(boltKey(url))
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.urlCachePath, importsDir), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.importFile(path), data); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.imported[path] = digest
+	return nil
+}
+
+// ForgetImported removes the record of an imported configuration file
+func (s *bucketStore) ForgetImported(path string) error {
+	if err := os.Remove(s.importFile(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.imported, path)
+	return nil
+}
+
+// ImportedFiles returns the paths of the imported configuration files
+func (s *bucketStore) ImportedFiles() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	paths := make([]string, 0, len(s.imported))
+	for path := range s.imported {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test resizing the cache with URLs both cached and evicted
+func TestResize(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	store := newBucketStore(urlCachePath, 3, 2)
+
+	all := append(append([]URLDBEntry{}, entries1.URLEntries...), entries2.URLEntries...)
+	for _, entry := range all {
+		url := URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+		if err := store.Put(url, &URLInfo{Category: entry.Category, Safe: *entry.Safe}); err != nil {
+			t.Errorf("Failed to add %v: %v\n", url, err)
+		}
+	}
+
+	for _, geometry := range []CacheGeometry{{Buckets: 7, Capacity: 3}, {Buckets: 2, Capacity: 100}} {
+		if err := store.Resize(geometry.Buckets, geometry.Capacity); err != nil {
+			t.Errorf("Failed to resize to %v: %v\n", geometry, err)
+			return
+		}
+		if len(store.urlht) != geometry.Buckets || store.maxUrlsCached != geometry.Capacity {
+			t.Errorf("Unexpected geometry %v, expected %v\n", *store.Stats(), geometry)
+		}
+
+		// Every URL is in the file of its bucket
+		found := make(URLDB)
+		for _, bucket := range store.urlht {
+			if err := readBucketFile(bucket.fileName, found); err != nil {
+				t.Errorf("Failed to read %v: %v\n", bucket.fileName, err)
+			}
+		}
+		for _, entry := range all {
+			info := found[URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}]
+			if info == nil || info.Category != entry.Category || info.Safe != *entry.Safe {
+				t.Errorf("Unmatched record %v after resizing to %v\n", entry, geometry)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(urlCachePath, "bucket6.json")); !os.IsNotExist(err) {
+		t.Errorf("The old bucket files are left after a resize: %v\n", err)
+	}
+
+	if err := store.Resize(0, 10); err == nil {
+		t.Errorf("Resizing to 0 buckets should fail\n")
+	}
+
+	// A resize that fails to write the new bucket files keeps the old ones
+	for i := 0; i < 5; i++ {
+		blocker := filepath.Join(urlCachePath, fmt.Sprintf("bucket%v.json%v", i, resizeSuffix), "blocker")
+		if err := os.MkdirAll(blocker, 0777); err != nil {
+			t.Errorf("Failed to create %v: %v\n", blocker, err)
+		}
+	}
+	if err := store.Resize(5, 10); err == nil || len(store.urlht) != 2 {
+		t.Errorf("Resized to %v buckets without writing the new files: %v\n", len(store.urlht), err)
+	}
+	for _, entry := range all {
+		url := URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+		infos, err := store.Get([]URL{url})
+		if err != nil || infos[0] == nil || infos[0].Category != entry.Category {
+			t.Errorf("Unmatched record %v after a failed resize: %v\n", entry, err)
+		}
+	}
+}
+
+// Test that a corrupted bucket file is rebuilt from the configuration files
+func TestRecoverBucket(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	all := append(append([]URLDBEntry{}, entries1.URLEntries...), entries2.URLEntries...)
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"), all...)
+	store := newBucketStore(urlCachePath, 3, 1)
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: urlCachePath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+
+	// Corrupt the bucket file of a URL not cached
+	url := URL{hostAndPort: all[0].HostAndPort, originalPath: all[0].OriginalPath}
+	fileName := store.urlht[hash(url.hostAndPort, len(store.urlht))].fileName
+	if err := ioutil.WriteFile(fileName, []byte(`{"urls":[{"host":`), 0666); err != nil {
+		t.Errorf("Failed to write %v: %v\n", fileName, err)
+	}
+
+	// The corrupted file is kept across a restart, and rebuilt when it's read
+	store.Close()
+	store = newBucketStore(urlCachePath, 3, 1)
+	server = &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: urlCachePath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+
+	infos, err := server.lookup([]URL{url})
+	if err != nil {
+		t.Errorf("Failed to look up %v: %v\n", url, err)
+	} else if infos[0].Category != all[0].Category {
+		t.Errorf("Looked up %v: %v, expected %v\n", url, *infos[0], all[0].Category)
+	}
+	if recoveries := store.Stats().Recoveries; recoveries != 1 {
+		t.Errorf("%v recoveries, expected 1\n", recoveries)
+	}
+
+	// The rebuilt file has all the URLs of the bucket
+	urldb := make(URLDB)
+	if err := readBucketFile(fileName, urldb); err != nil {
+		t.Errorf("Failed to read the rebuilt %v: %v\n", fileName, err)
+	}
+	for _, entry := range all {
+		url := URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+		if store.urlht[hash(url.hostAndPort, len(store.urlht))].fileName != fileName {
+			continue
+		}
+		if info := urldb[url]; info == nil || info.Category != entry.Category {
+			t.Errorf("%v is missing in the rebuilt %v\n", url, fileName)
+		}
+	}
+}
+
+// Test that the bucket files and the records of the imports are kept across
+// restarts with the same number of buckets, and discarded otherwise
+func TestBucketStoreRestart(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	all := make(URLDB)
+	urls := []URL{}
+	for _, entry := range append(append([]URLDBEntry{}, entries1.URLEntries...), entries2.URLEntries...) {
+		url := URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+		all[url] = &URLInfo{Category: entry.Category, Safe: *entry.Safe}
+		urls = append(urls, url)
+	}
+	store := newBucketStore(urlCachePath, 3, 2)
+	if err := store.PutAll(all); err != nil {
+		t.Errorf("Failed to add the urls: %v\n", err)
+	}
+	if err := store.SetImported("urlcfg1.json", "digest1", urls); err != nil {
+		t.Errorf("Failed to record the import: %v\n", err)
+	}
+	store.Close()
+
+	// A write cut short leaves a temporary file
+	leftover := filepath.Join(urlCachePath, "bucket0.json.tmp123")
+	if err := ioutil.WriteFile(leftover, []byte("{"), 0666); err != nil {
+		t.Errorf("Failed to write %v: %v\n", leftover, err)
+	}
+
+	store = newBucketStore(urlCachePath, 3, 2)
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("The temporary file is left after a restart: %v\n", err)
+	}
+	if digest, imported := store.Imported("urlcfg1.json"); digest != "digest1" || len(imported) != len(urls) {
+		t.Errorf("Imported %v urls with digest %v, expected %v with digest1\n", len(imported), digest, len(urls))
+	}
+	infos, err := store.Get(urls)
+	if err != nil {
+		t.Errorf("Failed to look up the urls: %v\n", err)
+	}
+	for i, url := range urls {
+		if err == nil && (infos[i] == nil || infos[i].Category != all[url].Category) {
+			t.Errorf("%v is lost after a restart\n", url)
+		}
+	}
+	store.Close()
+
+	// The bucket files of another number of buckets are discarded
+	store = newBucketStore(urlCachePath, 5, 2)
+	if files := store.ImportedFiles(); len(files) != 0 {
+		t.Errorf("Imported %v after changing the number of buckets\n", files)
+	}
+	infos, err = store.Get(urls)
+	if err != nil {
+		t.Errorf("Failed to look up the urls: %v\n", err)
+	}
+	for i, url := range urls {
+		if err == nil && infos[i] != nil {
+			t.Errorf("%v is kept after changing the number of buckets\n", url)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(urlCachePath, "bucket2.json")); !os.IsNotExist(err) {
+		t.Errorf("The old bucket files are left: %v\n", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+const (
+	defaultPort = "80"
+	// Bound the repeated percent-decoding of maliciously nested escapes
+	maxUnescapes = 1024
+)
+
+// Canonicalize a URL, modeled on the Safe Browsing URL canonicalization, so
+// that the different forms of the same URL map to the same key. The host is
+// lowercased and gets the default port, and the path is percent-decoded, has
+// its dot segments resolved, repeated slashes collapsed and fragment removed.
+func canonicalize(url URL) (URL, error) {
+	hostAndPort, err := canonicalHost(url.hostAndPort)
+	if err != nil {
+		return url, err
+	}
+	return URL{hostAndPort: hostAndPort, originalPath: canonicalPath(url.originalPath)}, nil
+}
+
+func canonicalHost(hostAndPort string) (string, error) {
+	hostAndPort = strings.TrimSpace(unescape(hostAndPort))
+	// Remove the user information
+	if at := strings.LastIndex(hostAndPort, "@"); at >= 0 {
+		hostAndPort = hostAndPort[at+1:]
+	}
+
+	host, port := hostAndPort, defaultPort
+	if h, p, err := net.SplitHostPort(hostAndPort); err == nil {
+		host, port = h, p
+	} else if strings.HasPrefix(hostAndPort, "[") && strings.HasSuffix(hostAndPort, "]") {
+		host = hostAndPort[1 : len(hostAndPort)-1]
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port in '%v'", hostAndPort)
+	}
+
+	// Lowercase, and remove the leading, trailing and repeated dots
+	labels := strings.FieldsFunc(strings.ToLower(host), func(r rune) bool { return r == '.' })
+	host = strings.Join(labels, ".")
+	if host == "" {
+		return "", fmt.Errorf("missing host in '%v'", hostAndPort)
+	}
+	return net.JoinHostPort(host, strings.TrimLeft(port, "0")), nil
+}
+
+func canonicalPath(path string) string {
+	if path == allPaths {
+		return path
+	}
+	// Strip the fragment, and the query string separator if the query is empty
+	if i := strings.Index(path, "#"); i >= 0 {
+		path = path[:i]
+	}
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	path = unescape(path)
+	trailingSlash := strings.HasSuffix(path, "/") && strings.Trim(path, "/") != ""
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	path = escape(strings.Join(segments, "/"))
+	if trailingSlash && len(segments) > 0 {
+		path += "/"
+	}
+
+	if query != "" {
+		path += "?" + escape(unescape(query))
+	}
+	return path
+}
+
+// Percent-decode repeatedly until nothing is left to decode
+func unescape(s string) string {
+	for i := 0; i < maxUnescapes && strings.Contains(s, "%"); i++ {
+		var b strings.Builder
+		for j := 0; j < len(s); j++ {
+			if s[j] == '%' && j+2 < len(s) && isHex(s[j+1]) && isHex(s[j+2]) {
+				v, _ := strconv.ParseUint(s[j+1:j+3], 16, 8)
+				b.WriteByte(byte(v))
+				j += 2
+				continue
+			}
+			b.WriteByte(s[j])
+		}
+		if b.String() == s {
+			break
+		}
+		s = b.String()
+	}
+	return s
+}
+
+// Percent-encode the control, space, non-ASCII, '#' and '%' characters
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '#' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// Convert a full URL such as http://www.cnn.com/news into its URL key
+func parseURL(rawurl string) (*URL, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in url '%v'", rawurl)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		port, ok := defaultPorts[u.Scheme]
+		if !ok {
+			return nil, fmt.Errorf("unsupported scheme in url '%v'", rawurl)
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		path = path + "?" + u.RawQuery
+	}
+	url, err := canonicalize(URL{hostAndPort: host, originalPath: path})
+	if err != nil {
+		return nil, err
+	}
+	return &url, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		url       URL
+		canonical URL
+	}{
+		{URL{"www.cnn.com:80", "news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"WWW.CNN.COM:80", "news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com", "news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com.:080", "news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"user@www..cnn.com", "news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "%6eews"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "%256eews"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "news?"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "news#top"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "/a/./b/../news"}, URL{"www.cnn.com:80", "a/news"}},
+		{URL{"www.cnn.com:80", "a//b///news/"}, URL{"www.cnn.com:80", "a/b/news/"}},
+		{URL{"www.cnn.com:80", "../../news"}, URL{"www.cnn.com:80", "news"}},
+		{URL{"www.cnn.com:80", "a b?q=%41 c"}, URL{"www.cnn.com:80", "a%20b?q=A%20c"}},
+		{URL{"www.cnn.com:80", "*"}, URL{"www.cnn.com:80", "*"}},
+		{URL{"*.CNN.com", "*"}, URL{"*.cnn.com:80", "*"}},
+		{URL{"[::1]", "news"}, URL{"[::1]:80", "news"}},
+	}
+	for _, test := range tests {
+		canonical, err := canonicalize(test.url)
+		if err != nil {
+			t.Errorf("Failed to canonicalize %v: %v\n", test.url, err)
+			continue
+		}
+		if canonical != test.canonical {
+			t.Errorf("Canonicalized %v into %v, expected %v\n", test.url, canonical, test.canonical)
+		}
+	}
+
+	for _, host := range []string{"www.cnn.com:http", "www.cnn.com:", "www.cnn.com:99999", ":80"} {
+		if _, err := canonicalize(URL{hostAndPort: host, originalPath: "news"}); err == nil {
+			t.Errorf("Canonicalizing malformed host '%v' should fail\n", host)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The link through which kubelet swaps in a new version of a ConfigMap volume.
+// The files of the ConfigMap are links to the files under it.
+const configMapDataDir = "..data"
+
+// configFiles finds the configuration files under a directory, and returns the
+// source to read each of them from. In a ConfigMap volume, they are read from
+// the version ..data links to rather than through their own links, so that
+// they are all from the same version. Hidden files and directories, including
+// the versions of a ConfigMap volume, are skipped.
+func configFiles(dir string) (map[string]string, error) {
+	root := dir
+	if data, err := filepath.EvalSymlinks(filepath.Join(dir, configMapDataDir)); err == nil {
+		root = data
+	}
+
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(source string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if source != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !supportedExtensions[filepath.Ext(source)] {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(source); err != nil {
+				logger.warnf("Skip %s: %v", source, err)
+				return nil
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, source)
+		if err != nil {
+			return err
+		}
+		files[filepath.Join(dir, rel)] = source
+		return nil
+	})
+	return files, err
+}
+
+// entryKey identifies a url record by its canonical URL, and whether it's a path
+// prefix rule
+type entryKey struct {
+	url    URL
+	prefix bool
+}
+
+func (key entryKey) String() string {
+	if key.prefix {
+		return ruleString(key.url.hostAndPort, key.url.originalPath)
+	}
+	return key.url.hostAndPort + "/" + key.url.originalPath
+}
+
+// fileEntries are the url records of a configuration file
+type fileEntries map[entryKey]*URLInfo
+
+// Tell if a record goes to the store rather than to the host rules
+func (key entryKey) stored() bool {
+	return !key.prefix && !isHostRule(&key.url)
+}
+
+// The records that have not expired at now
+func (entries fileEntries) unexpired(now string) fileEntries {
+	kept := make(fileEntries)
+	for key, info := range entries {
+		if info == nil || !info.expired(now) {
+			kept[key] = info
+		}
+	}
+	return kept
+}
+
+// verdictDigest summarizes the verdict of a URL, so that the verdicts of the
+// configuration files can be compared without keeping them in memory. It's
+// never 0, which stands for an unknown verdict.
+func verdictDigest(info *URLInfo) uint64 {
+	data, _ := json.Marshal(info)
+	digest := fnv.New64a()
+	digest.Write(data)
+	if sum := digest.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// configFile is a version of a configuration file. Only the digests of the
+// verdicts of its URLs stay in memory, along with its rules, which the host
+// rules are built from. Its records are read again from the stash when they
+// are needed, except for the pinned ones.
+type configFile struct {
+	digest string
+	// urls are the digests of the verdicts of the records that go to the store
+	urls  map[URL]uint64
+	rules fileEntries
+	// expiries are the sorted expiry times of the records that expire
+	expiries []string
+	// pinned are the records of a file that is not stashed, such as the
+	// records added with the admin API. A nil record deletes the URL from
+	// the other files.
+	pinned fileEntries
+	// swept is when the file was last swept. The records expired by then are
+	// dropped when they are read again.
+	swept string
+	// data and parsed are the content and the records of a file that was
+	// just read, until it's stashed
+	data   []byte
+	parsed fileEntries
+}
+
+// newConfigFile indexes the records of a version of a configuration file
+func newConfigFile(digest string, entries fileEntries) *configFile {
+	file := &configFile{digest: digest, urls: make(map[URL]uint64), rules: make(fileEntries)}
+	for key, info := range entries {
+		if info == nil {
+			continue
+		}
+		if info.Expires != "" {
+			file.expiries = append(file.expiries, info.Expires)
+		}
+		if key.stored() {
+			file.urls[key.url] = verdictDigest(info)
+		} else {
+			file.rules[key] = info
+		}
+	}
+	sort.Strings(file.expiries)
+	return file
+}
+
+// newPinnedFile is a configuration file that keeps its records in memory
+func newPinnedFile(digest string, entries fileEntries) *configFile {
+	file := newConfigFile(digest, entries)
+	file.pinned = entries
+	return file
+}
+
+// has tells if the file has a record
+func (file *configFile) has(key entryKey) bool {
+	if key.stored() {
+		_, ok := file.urls[key.url]
+		return ok
+	}
+	_, ok := file.rules[key]
+	return ok
+}
+
+// expired returns how many records have expired at now
+func (file *configFile) expired(now string) int {
+	if file.swept > now {
+		now = file.swept
+	}
+	return sort.Search(len(file.expiries), func(i int) bool { return file.expiries[i] > now })
+}
+
+// entries returns the records of the file that have not expired at now, or
+// when it was swept, reading them again from the stash unless they are pinned
+func (file *configFile) entries(stash *configStash, tax *taxonomy, now string) (fileEntries, error) {
+	if file.swept > now {
+		now = file.swept
+	}
+	if file.pinned != nil {
+		if file.expired(now) > 0 {
+			return file.pinned.unexpired(now), nil
+		}
+		return file.pinned, nil
+	}
+	data, err := stash.get(file.digest)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := parseConfigData(data, tax, now)
+	return entries, err
+}
+
+// Parse and validate url records. A URL may be repeated with the same verdict,
+// but not with conflicting ones. The records expired at now are skipped, and
+// counted.
+func parseEntries(entries []URLDBEntry, tax *taxonomy, now string) (fileEntries, int, error) {
+	parsed := make(fileEntries)
+	expired := 0
+	for i := range entries {
+		url, info, err := parseEntry(&entries[i], tax)
+		if err != nil {
+			return nil, 0, fmt.Errorf("record %v: %v", i, err)
+		}
+		if info.expired(now) {
+			expired++
+			continue
+		}
+		key := entryKey{url: url, prefix: entries[i].Match == matchPrefix}
+		if existing, ok := parsed[key]; ok {
+			if !existing.equal(info) {
+				return nil, 0, fmt.Errorf("record %v: conflicting verdicts for %v", i, key)
+			}
+			logger.warnf("Duplicate record %v for %v", i, key)
+		}
+		parsed[key] = info
+	}
+	return parsed, expired, nil
+}
+
+// Decode the url records of a configuration file, and parse them. Unknown
+// fields and trailing data are rejected.
+func parseConfigData(data []byte, tax *taxonomy, now string) (fileEntries, int, error) {
+	var urls URLs
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&urls); err != nil {
+		return nil, 0, err
+	}
+	if _, trailing := decoder.Token(); trailing != io.EOF {
+		return nil, 0, fmt.Errorf("trailing data after the url records")
+	}
+	return parseEntries(urls.URLEntries, tax, now)
+}
+
+// Read, parse and validate a configuration file from source, which is not its
+// path in a ConfigMap volume. The digest of the file covers the taxonomy, which
+// its records are mapped to.
+func readConfigFile(path, source string, tax *taxonomy) (*configFile, error) {
+	defer configLoadSeconds.since(time.Now())
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		logger.errorf("Failed to read %s: %v", path, err)
+		return nil, err
+	}
+
+	entries, expired, err := parseConfigData(data, tax, expiryNow())
+	if err != nil {
+		logger.warnf("Invalid %s: %v", path, err)
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if expired > 0 {
+		logger.infof("Skipped %v expired records", expired)
+		purgedTotal.add(float64(expired))
+	}
+	logger.infof("Read %v urls from %s", len(entries), path)
+	digest := sha256.New()
+	digest.Write(data)
+	if tax != nil {
+		digest.Write([]byte(tax.digest))
+	}
+	file := newConfigFile(fmt.Sprintf("%x", digest.Sum(nil)), entries)
+	file.data, file.parsed = data, entries
+	return file, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// atomicWrite updates a ConfigMap volume the way kubelet's AtomicWriter does:
+// it writes the files to a new hidden version directory, swaps the ..data link
+// to it, links the files that are new, removes the links of the files that are
+// gone, and then removes the old version.
+func atomicWrite(t *testing.T, dir string, files map[string]*URLs) {
+	version, err := ioutil.TempDir(dir, "..version_")
+	if err != nil {
+		t.Errorf("Failed to create version dir: %v\n", err)
+		return
+	}
+	for name, urls := range files {
+		data, err := json.Marshal(urls)
+		if err != nil {
+			t.Errorf("Failed to Marshall: %v\n", err)
+			return
+		}
+		if err := ioutil.WriteFile(filepath.Join(version, name), data, 0644); err != nil {
+			t.Errorf("Failed to write %v: %v\n", name, err)
+		}
+	}
+
+	dataDir := filepath.Join(dir, configMapDataDir)
+	oldVersion, _ := os.Readlink(dataDir)
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(version), tmpLink); err != nil {
+		t.Errorf("Failed to link %v: %v\n", tmpLink, err)
+	}
+	if err := os.Rename(tmpLink, dataDir); err != nil {
+		t.Errorf("Failed to rename %v: %v\n", tmpLink, err)
+	}
+
+	for name := range files {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join(configMapDataDir, name), link); err != nil {
+				t.Errorf("Failed to link %v: %v\n", link, err)
+			}
+		}
+	}
+	links, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, link := range links {
+		if files[filepath.Base(link)] == nil {
+			os.Remove(link)
+		}
+	}
+	if oldVersion != "" {
+		os.RemoveAll(filepath.Join(dir, oldVersion))
+	}
+}
+
+// Test that the files of a ConfigMap volume are loaded under their own names,
+// and reloaded when kubelet swaps in a new version
+func TestConfigMapReload(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)}
+	sports := URLDBEntry{HostAndPort: "www.espn.com:80", OriginalPath: "programming", Category: "sports", Safe: boolPtr(true)}
+	atomicWrite(t, urlCfgPath, map[string]*URLs{
+		"urlcfg1.json": {URLEntries: []URLDBEntry{news}},
+		"urlcfg2.json": {URLEntries: []URLDBEntry{sports}},
+	})
+
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: newMemStore()}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+		return
+	}
+	files := server.currentFiles()
+	paths := []string{}
+	for path := range files {
+		paths = append(paths, path)
+	}
+	expected := []string{filepath.Join(urlCfgPath, "urlcfg1.json"), filepath.Join(urlCfgPath, "urlcfg2.json")}
+	if len(paths) != 2 || files[expected[0]] == nil || files[expected[1]] == nil {
+		t.Errorf("Loaded %v, expected %v\n", paths, expected)
+	}
+
+	if err := server.watchForUpdate(); err != nil {
+		t.Errorf("Failed to watch %v: %v\n", urlCfgPath, err)
+		return
+	}
+	urls := []URL{{"www.cnn.com:80", "news"}, {"www.espn.com:80", "programming"}, {"www.food.com:80", "recipes"}}
+	waitFor := func(categories ...string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if reflect.DeepEqual(lookupCategories(server, urls...), categories) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("%v are %v, expected %v\n", urls, lookupCategories(server, urls...), categories)
+	}
+	waitFor("news", "sports", "Unknown")
+
+	// Change a file, remove one and add one
+	news.Category = "fake-news"
+	food := URLDBEntry{HostAndPort: "www.food.com:80", OriginalPath: "recipes", Category: "food", Safe: boolPtr(true)}
+	atomicWrite(t, urlCfgPath, map[string]*URLs{
+		"urlcfg1.json": {URLEntries: []URLDBEntry{news}},
+		"urlcfg3.json": {URLEntries: []URLDBEntry{food}},
+	})
+	waitFor("fake-news", "Unknown", "food")
+
+	// A version that doesn't parse is not loaded at all
+	version, _ := os.Readlink(filepath.Join(urlCfgPath, configMapDataDir))
+	if err := ioutil.WriteFile(filepath.Join(urlCfgPath, version, "urlcfg4.json"), []byte("{"), 0644); err != nil {
+		t.Errorf("Failed to write: %v\n", err)
+	}
+	if err := server.loadURLs(); err == nil {
+		t.Errorf("Loaded a version with an invalid file\n")
+	}
+	waitFor("fake-news", "Unknown", "food")
+}
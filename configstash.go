@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The directory of the cache path where the versions of the configuration
+// files are stashed
+const stashDir = "configs"
+
+// configStash keeps the content of the versions of the configuration files
+// that the snapshots are built from, by digest, so that their records can be
+// read again for a rollback, a retraction, a sweep, or to rebuild a corrupted
+// bucket file, even once the files have changed. It's in the cache path, or in
+// memory without one.
+type configStash struct {
+	dir  string
+	lock sync.Mutex
+	// data is the content of the versions without a dir
+	data map[string][]byte
+}
+
+// newConfigStash creates the stash of the cache path, emptying what is left of
+// a previous run
+func newConfigStash(urlCachePath string) *configStash {
+	if urlCachePath == "" {
+		return &configStash{data: make(map[string][]byte)}
+	}
+	dir := filepath.Join(urlCachePath, stashDir)
+	if err := os.RemoveAll(dir); err != nil {
+		logger.warnf("Failed to empty %v: %v", dir, err)
+	}
+	return &configStash{dir: dir}
+}
+
+func (c *configStash) fileName(digest string) string {
+	return filepath.Join(c.dir, digest+".json")
+}
+
+// put stashes a version of a configuration file, unless it's already stashed,
+// as the digest is of the content
+func (c *configStash) put(digest string, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dir == "" {
+		if _, ok := c.data[digest]; !ok {
+			c.data[digest] = data
+		}
+		return nil
+	}
+	if _, err := os.Stat(c.fileName(digest)); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.fileName(digest), data)
+}
+
+// get returns the content of a version of a configuration file
+func (c *configStash) get(digest string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dir == "" {
+		data, ok := c.data[digest]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+	return ioutil.ReadFile(c.fileName(digest))
+}
+
+// keep drops the versions whose digests are not in digests
+func (c *configStash) keep(digests map[string]bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dir == "" {
+		for digest := range c.data {
+			if !digests[digest] {
+				delete(c.data, digest)
+			}
+		}
+		return
+	}
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		digest := strings.TrimSuffix(info.Name(), ".json")
+		if !digests[digest] {
+			if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
+				logger.warnf("Failed to remove %v: %v", info.Name(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Test that the stash keeps the versions of the configuration files of the
+// current snapshot and the previous one, and that the records are read again
+// from it once the files have changed
+func TestStashVersions(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	store, remove := newTestBucketStore(t, 3, 1)
+	defer remove()
+
+	cfg := filepath.Join(urlCfgPath, "urlcfg1.json")
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: store.urlCachePath, store: store}
+	digests := []string{}
+	for _, category := range []string{"news", "fake-news", "satire"} {
+		writeURLs(t, cfg, URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: category})
+		if err := server.loadURLs(); err != nil {
+			t.Errorf("Failed to load url Config: %v\n", err)
+		}
+		digests = append(digests, server.snapshots().Current.Files[cfg])
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(store.urlCachePath, stashDir))
+	if err != nil {
+		t.Errorf("Failed to read the stash: %v\n", err)
+	}
+	stashed := []string{}
+	for _, info := range infos {
+		stashed = append(stashed, info.Name())
+	}
+	expected := []string{digests[1] + ".json", digests[2] + ".json"}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(stashed, expected) {
+		t.Errorf("Stashed %v, expected %v\n", stashed, expected)
+	}
+
+	os.Remove(cfg)
+	if err := server.rollback(); err != nil {
+		t.Errorf("Failed to roll back: %v\n", err)
+	}
+	if categories := lookupCategories(server, URL{"www.cnn.com:80", "news"}); categories[0] != "fake-news" {
+		t.Errorf("Looked up %v after the rollback, expected fake-news\n", categories)
+	}
+}
+
+// Test that a version already stashed is not written again
+func TestStashPutOnce(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	for _, stash := range []*configStash{newConfigStash(urlCachePath), newConfigStash("")} {
+		for _, data := range []string{"first", "second"} {
+			if err := stash.put("digest", []byte(data)); err != nil {
+				t.Errorf("Failed to stash %v: %v\n", data, err)
+			}
+		}
+		if data, err := stash.get("digest"); err != nil || string(data) != "first" {
+			t.Errorf("Stashed %s, expected first: %v\n", data, err)
+		}
+	}
+}
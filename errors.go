@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// Codes of the error responses
+const (
+	errInvalidRequest   = "invalid_request"
+	errInvalidURL       = "invalid_url"
+	errBatchTooLarge    = "batch_too_large"
+	errStoreUnavailable = "store_unavailable"
+	errNotResizable     = "not_resizable"
+	errNoSnapshot       = "no_snapshot"
+	errNoRoute          = "no_route"
+	errInvalidRecord    = "invalid_record"
+	errNotFound         = "not_found"
+	errUnauthorized     = "unauthorized"
+	errAdminDisabled    = "admin_disabled"
+	errRateLimited      = "rate_limited"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	requestIDAttribute = "request-id"
+	maxRequestIDLength = 128
+)
+
+// APIError is the body of an error response
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		logger.errorf("Failed to generate a request ID: %v", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// requestIDFilter tags a request with the ID in its X-Request-ID header, or with
+// a new one, and returns the ID in the same header of the response
+func requestIDFilter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	id := request.HeaderParameter(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = newRequestID()
+	}
+	request.SetAttribute(requestIDAttribute, id)
+	response.AddHeader(requestIDHeader, id)
+	chain.ProcessFilter(request, response)
+}
+
+// requestID returns the ID that requestIDFilter has tagged a request with
+func requestID(request *restful.Request) string {
+	id, _ := request.Attribute(requestIDAttribute).(string)
+	return id
+}
+
+// writeError writes an error response with a JSON body, and logs it as an
+// error if the server failed, or at the info level if the client did
+func writeError(request *restful.Request, response *restful.Response, status int, code string, err error) {
+	level := levelInfo
+	if status >= http.StatusInternalServerError {
+		level = levelError
+	}
+	logger.logf(level, "Request %v failed with %v: %v", requestID(request), status, err)
+	writeAPIError(request, response, status, code, err)
+}
+
+// writeAPIError writes an error response with a JSON body
+func writeAPIError(request *restful.Request, response *restful.Response, status int, code string, err error) {
+	apiErr := &APIError{Code: code, Message: err.Error(), RequestID: requestID(request)}
+	if err := response.WriteHeaderAndJson(status, apiErr, restful.MIME_JSON); err != nil {
+		logger.warnf("Failed to write error: %v", err)
+	}
+}
+
+// writeServiceError writes the errors of the requests that match no route, such
+// as 404 and 405, with a JSON body
+func writeServiceError(serviceErr restful.ServiceError, request *restful.Request, response *restful.Response) {
+	writeError(request, response, serviceErr.Code, errNoRoute, errors.New(serviceErr.Message))
+}
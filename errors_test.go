@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingStore is a store whose disk can't be read
+type failingStore struct {
+	*memStore
+}
+
+func (s *failingStore) Get(urls []URL) ([]*URLInfo, error) {
+	return nil, fmt.Errorf("disk failure")
+}
+
+// Serve a request with the container of a server, and decode the response
+func serveRequest(server *urlLookupServer, method, path, body, id string, entity interface{}) (int, string) {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if id != "" {
+		request.Header.Set(requestIDHeader, id)
+	}
+	recorder := httptest.NewRecorder()
+	server.newContainer().ServeHTTP(recorder, request)
+	json.Unmarshal(recorder.Body.Bytes(), entity)
+	return recorder.Code, recorder.Header().Get(requestIDHeader)
+}
+
+// Test the status codes and the error bodies of the lookups
+func TestLookupErrors(t *testing.T) {
+	server := &urlLookupServer{store: newMemStore()}
+	if err := server.addEntry(&URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "News", Safe: boolPtr(true)}); err != nil {
+		t.Errorf("Failed to add an entry: %v\n", err)
+	}
+
+	info := &URLInfo{}
+	status, id := serveRequest(server, "GET", "/urlinfo/2/www.cnn.com:80/news", "", "", info)
+	if status != http.StatusOK || !info.Found || info.Category != "News" || id == "" {
+		t.Errorf("Looked up a stored URL: %v, %v, %v\n", status, *info, id)
+	}
+	info = &URLInfo{}
+	status, _ = serveRequest(server, "GET", "/urlinfo/2/www.cnn.com:80/sports", "", "", info)
+	if status != http.StatusOK || info.Found || info.Category != notFound.Category {
+		t.Errorf("Looked up an unknown URL: %v, %v\n", status, *info)
+	}
+
+	tests := []struct {
+		store  Store
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{server.store, "GET", "/urlinfo/1/www.cnn.com:http/news", "", http.StatusBadRequest, errInvalidURL},
+		{server.store, "GET", "/urlinfo/1/:80/news", "", http.StatusBadRequest, errInvalidURL},
+		{server.store, "POST", "/urlinfo/1/batch", `[{"host": "www.cnn.com:x"}]`, http.StatusBadRequest, errInvalidURL},
+		{server.store, "POST", "/urlinfo/1/batch", `{`, http.StatusBadRequest, errInvalidRequest},
+		{server.store, "GET", "/nothing", "", http.StatusNotFound, errNoRoute},
+		{&failingStore{newMemStore()}, "GET", "/urlinfo/1/www.cnn.com:80/news", "", http.StatusServiceUnavailable, errStoreUnavailable},
+		{&failingStore{newMemStore()}, "POST", "/urlinfo/1/batch", `[{"url": "http://www.cnn.com/news"}]`,
+			http.StatusServiceUnavailable, errStoreUnavailable},
+	}
+	for _, test := range tests {
+		server := &urlLookupServer{store: test.store}
+		apiErr := &APIError{}
+		status, id := serveRequest(server, test.method, test.path, test.body, "req-1", apiErr)
+		if status != test.status || apiErr.Code != test.code || apiErr.Message == "" {
+			t.Errorf("%v %v returned %v, %v, expected %v, %v\n", test.method, test.path, status, *apiErr, test.status, test.code)
+		}
+		if apiErr.RequestID != "req-1" || id != "req-1" {
+			t.Errorf("%v %v returned request ID %v and %v, expected req-1\n", test.method, test.path, apiErr.RequestID, id)
+		}
+	}
+}
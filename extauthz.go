@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// The path prefix of the http checks, which is the path_prefix of the http
+// service of the ext_authz filter
+const extAuthzPath = "/ext_authz"
+
+// The headers that the checks return with the verdict and the category of a
+// URL, which Envoy can pass on to the upstreams
+const (
+	verdictHeader  = "X-URL-Verdict"
+	categoryHeader = "X-URL-Category"
+)
+
+// The methods of the requests that Envoy checks over http, which keep the
+// method of the original request
+var extAuthzMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE"}
+
+// extAuthzOptions defines the Envoy external authorization mode
+type extAuthzOptions struct {
+	enabled bool
+	// Status and body of the responses that deny the unsafe URLs
+	deniedStatus int
+	deniedBody   string
+}
+
+// validate checks that the denied status is an error that Envoy can return
+func (opts *extAuthzOptions) validate() error {
+	if _, ok := typev3.StatusCode_name[int32(opts.deniedStatus)]; !ok || opts.deniedStatus < 400 {
+		return fmt.Errorf("invalid ext_authz denied status %v", opts.deniedStatus)
+	}
+	return nil
+}
+
+// checkURL returns the URL of a request that Envoy checks, from its scheme,
+// :authority and :path
+func checkURL(scheme, authority, path string) (*URL, error) {
+	if scheme == "" {
+		scheme = "http"
+	}
+	if authority == "" {
+		return nil, fmt.Errorf("missing authority")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return parseURL(scheme + "://" + authority + path)
+}
+
+// denied tells if a check denies the request to a URL. The unknown URLs are
+// allowed.
+func denied(info *URLInfo) bool {
+	return verdictOf(info) == "unsafe"
+}
+
+// newExtAuthzService creates the web service of the http checks of Envoy.
+// Envoy sends them with the method, the path under the prefix, and the Host
+// of the original request, and allows it on 200, while it returns any other
+// response to the client.
+func (s *urlLookupServer) newExtAuthzService() *restful.WebService {
+	ws := &restful.WebService{}
+	ws.Path(extAuthzPath)
+	for _, method := range extAuthzMethods {
+		for _, path := range []string{"", "/{path:*}"} {
+			ws.Route(ws.
+				Method(method).
+				Path(path).
+				To(s.checkHTTP).
+				Doc("Check a request of Envoy's ext_authz filter"))
+		}
+	}
+	return ws
+}
+
+// checkHTTP checks the URL of a request of Envoy's http ext_authz filter
+func (s *urlLookupServer) checkHTTP(request *restful.Request, response *restful.Response) {
+	start := time.Now()
+	path := strings.TrimPrefix(request.Request.URL.EscapedPath(), extAuthzPath)
+	if query := request.Request.URL.RawQuery; query != "" {
+		path = path + "?" + query
+	}
+	url, err := checkURL(request.HeaderParameter("X-Forwarded-Proto"), request.Request.Host, path)
+	if err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidURL, err)
+		return
+	}
+	urlinfos, loaded, err := s.lookupLoaded([]URL{*url})
+	if err != nil {
+		writeError(request, response, http.StatusServiceUnavailable, errStoreUnavailable, err)
+		return
+	}
+	s.logAccess(request, start, []URL{*url}, urlinfos, loaded)
+
+	info := urlinfos[0]
+	response.AddHeader(verdictHeader, verdictOf(info))
+	response.AddHeader(categoryHeader, info.Category)
+	if !denied(info) {
+		response.WriteHeader(http.StatusOK)
+		return
+	}
+	response.AddHeader("Content-Type", http.DetectContentType([]byte(s.extAuthz.deniedBody)))
+	response.WriteHeader(s.extAuthz.deniedStatus)
+	if _, err := response.Write([]byte(s.extAuthz.deniedBody)); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+// extAuthzServer serves the gRPC checks of Envoy's ext_authz filter
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	g *grpcLookupServer
+}
+
+// A header of a check response
+func headerOption(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: strings.ToLower(key), Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// Check checks the URL of a request of Envoy's gRPC ext_authz filter. A
+// failure of the store fails the call, so that Envoy applies its
+// failure_mode_allow.
+func (e *extAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	// Log the lookups with the request ID of Envoy
+	if id := httpReq.GetId(); id != "" && len(id) <= maxRequestIDLength {
+		callOf(ctx).requestID = id
+	}
+	url, err := checkURL(httpReq.GetScheme(), httpReq.GetHost(), httpReq.GetPath())
+	if err != nil {
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.InvalidArgument), Message: err.Error()},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_BadRequest},
+				Body:   err.Error(),
+			}},
+		}, nil
+	}
+	urlinfos, err := e.g.lookup(ctx, []URL{*url})
+	if err != nil {
+		return nil, err
+	}
+
+	info := urlinfos[0]
+	headers := []*corev3.HeaderValueOption{
+		headerOption(verdictHeader, verdictOf(info)),
+		headerOption(categoryHeader, info.Category),
+	}
+	if !denied(info) {
+		return &authv3.CheckResponse{
+			Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{Headers: headers}},
+		}, nil
+	}
+	opts := e.g.s.extAuthz
+	headers = append(headers, headerOption("Content-Type", http.DetectContentType([]byte(opts.deniedBody))))
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied), Message: fmt.Sprintf("%v/%v is unsafe", url.hostAndPort, url.originalPath)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: typev3.StatusCode(opts.deniedStatus)},
+			Headers: headers,
+			Body:    opts.deniedBody,
+		}},
+	}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+// A server in the ext_authz mode with a safe and an unsafe URL
+func newExtAuthzServer(t *testing.T) *urlLookupServer {
+	server := &urlLookupServer{
+		store:    newMemStore(),
+		extAuthz: &extAuthzOptions{enabled: true, deniedStatus: http.StatusUnavailableForLegalReasons, deniedBody: "blocked"},
+	}
+	entries := []*URLDBEntry{
+		{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)},
+		{HostAndPort: "www.evil.com:443", OriginalPath: "login?user=1", Category: "phishing", Safe: boolPtr(false)},
+	}
+	for _, entry := range entries {
+		if err := server.addEntry(entry); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entry, err)
+		}
+	}
+	return server
+}
+
+// Test the http checks of Envoy, which are the original requests under the
+// path prefix
+func TestExtAuthzHTTP(t *testing.T) {
+	server := newExtAuthzServer(t)
+	tests := []struct {
+		method   string
+		host     string
+		scheme   string
+		path     string
+		status   int
+		verdict  string
+		category string
+	}{
+		{"GET", "www.cnn.com", "", "/news", http.StatusOK, "safe", "news"},
+		{"POST", "WWW.CNN.COM:80", "http", "/news", http.StatusOK, "safe", "news"},
+		{"GET", "www.evil.com", "https", "/login?user=1", http.StatusUnavailableForLegalReasons, "unsafe", "phishing"},
+		{"GET", "www.evil.com", "http", "/login?user=1", http.StatusOK, "unknown", notFound.Category},
+		{"DELETE", "www.unknown.com", "", "", http.StatusOK, "unknown", notFound.Category},
+		{"GET", "www.cnn.com:http", "", "/news", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, extAuthzPath+test.path, nil)
+		request.Host = test.host
+		if test.scheme != "" {
+			request.Header.Set("X-Forwarded-Proto", test.scheme)
+		}
+		recorder := httptest.NewRecorder()
+		server.newContainer().ServeHTTP(recorder, request)
+		if recorder.Code != test.status || recorder.Header().Get(verdictHeader) != test.verdict ||
+			recorder.Header().Get(categoryHeader) != test.category {
+			t.Errorf("Check of %v %v%v returned %v, %v, expected %v\n", test.method, test.host, test.path,
+				recorder.Code, recorder.Header(), test.status)
+		}
+		if test.verdict == "unsafe" && recorder.Body.String() != "blocked" {
+			t.Errorf("Check of %v%v returned body %v\n", test.host, test.path, recorder.Body.String())
+		}
+	}
+
+	// The checks are only served in the ext_authz mode
+	server.extAuthz = nil
+	request := httptest.NewRequest("GET", extAuthzPath+"/news", nil)
+	request.Host = "www.cnn.com"
+	recorder := httptest.NewRecorder()
+	server.newContainer().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Check without the ext_authz mode returned %v\n", recorder.Code)
+	}
+}
+
+// Test the gRPC checks of Envoy with a fake Envoy
+func TestExtAuthzGRPC(t *testing.T) {
+	server := newExtAuthzServer(t)
+	conn, stop := startGRPC(t, server)
+	defer stop()
+	envoy := authv3.NewAuthorizationClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	check := func(scheme, host, path string) (*authv3.CheckResponse, error) {
+		return envoy.Check(ctx, &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+				Id: "envoy-1", Method: "GET", Scheme: scheme, Host: host, Path: path,
+			}},
+		}})
+	}
+	tests := []struct {
+		scheme string
+		host   string
+		path   string
+		code   codes.Code
+		status int
+	}{
+		{"http", "www.cnn.com", "/news", codes.OK, 0},
+		{"https", "www.evil.com", "/login?user=1", codes.PermissionDenied, http.StatusUnavailableForLegalReasons},
+		{"https", "www.unknown.com", "/", codes.OK, 0},
+		{"http", "", "/news", codes.InvalidArgument, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		resp, err := check(test.scheme, test.host, test.path)
+		if err != nil || codes.Code(resp.Status.Code) != test.code {
+			t.Errorf("Check of %v%v returned %v, %v\n", test.host, test.path, resp, err)
+			continue
+		}
+		if test.code == codes.OK {
+			if resp.GetOkResponse() == nil {
+				t.Errorf("Check of %v%v returned no OK response: %v\n", test.host, test.path, resp)
+			}
+			continue
+		}
+		denied := resp.GetDeniedResponse()
+		if denied == nil || int(denied.Status.Code) != test.status {
+			t.Errorf("Check of %v%v returned %v, expected %v\n", test.host, test.path, resp, test.status)
+		}
+		if test.code == codes.PermissionDenied && denied.Body != "blocked" {
+			t.Errorf("Check of %v%v returned body %v\n", test.host, test.path, denied.Body)
+		}
+	}
+
+	if err := (&extAuthzOptions{deniedStatus: 200}).validate(); err == nil {
+		t.Errorf("Validated a denied status of 200\n")
+	}
+}
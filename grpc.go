@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baodongli/url-lookup/urllookuppb"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The metadata keys of the gRPC API, which are the lower case headers of the
+// REST API
+var (
+	grpcAPIKeyKey    = strings.ToLower(apiKeyHeader)
+	grpcRequestIDKey = strings.ToLower(requestIDHeader)
+)
+
+// The trailer of the seconds to wait before retrying a call over the rate
+// limit
+const grpcRetryAfterKey = "retry-after"
+
+// grpcCall is the client and the request ID of a call, which the interceptors
+// put in its context
+type grpcCall struct {
+	client    string
+	requestID string
+}
+
+type grpcCallKey struct{}
+
+// The call of a context
+func callOf(ctx context.Context) *grpcCall {
+	if call, ok := ctx.Value(grpcCallKey{}).(*grpcCall); ok {
+		return call
+	}
+	return &grpcCall{}
+}
+
+// grpcLookupServer serves the gRPC API of the lookups
+type grpcLookupServer struct {
+	urllookuppb.UnimplementedURLLookupServer
+	s *urlLookupServer
+}
+
+// newGRPCServer creates the gRPC server of the lookups, with the TLS
+// configuration of the REST API if any
+func (s *urlLookupServer) newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	g := &grpcLookupServer{s: s}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(g.unaryInterceptor),
+		grpc.StreamInterceptor(g.streamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	urllookuppb.RegisterURLLookupServer(server, g)
+	if s.extAuthz != nil {
+		authv3.RegisterAuthorizationServer(server, &extAuthzServer{g: g})
+	}
+	return server
+}
+
+// authorize checks the client certificate and the API key of a call like
+// authFilter, and returns its context with the call
+func (g *grpcLookupServer) authorize(ctx context.Context) (context.Context, *grpcCall, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	call := &grpcCall{requestID: first(grpcRequestIDKey)}
+	if call.requestID == "" || len(call.requestID) > maxRequestIDLength {
+		call.requestID = newRequestID()
+	}
+
+	var remoteAddr string
+	var tlsInfo credentials.TLSInfo
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+		tlsInfo, _ = p.AuthInfo.(credentials.TLSInfo)
+	}
+	if g.s.requireClientCert && len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil, call, status.Error(codes.Unauthenticated, "a client certificate is required")
+	}
+	apiKey := first(grpcAPIKeyKey)
+	if g.s.apiKeys != nil && !g.s.apiKeys.valid(apiKey) {
+		return nil, call, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	call.client = g.s.client(apiKey, remoteAddr)
+	return context.WithValue(ctx, grpcCallKey{}, call), call, nil
+}
+
+// allow takes a token of the rate limit of a call, or returns the error of
+// the call over it
+func (g *grpcLookupServer) allow(call *grpcCall) error {
+	if g.s.rateLimiter == nil {
+		return nil
+	}
+	ok, wait := g.s.rateLimiter.allow(call.client, time.Now())
+	if ok {
+		return nil
+	}
+	countThrottled(call.client)
+	return &throttledError{wait: wait, client: call.client}
+}
+
+// throttledError is the error of a call over the rate limit
+type throttledError struct {
+	wait   time.Duration
+	client string
+}
+
+// The seconds to wait before retrying
+func (e *throttledError) retryAfter() string {
+	return strconv.Itoa(int(math.Ceil(e.wait.Seconds())))
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("client %v is over its rate limit, retry after %vs", e.client, e.retryAfter())
+}
+
+// Log a failed call like writeError
+func logCall(call *grpcCall, method string, err error) {
+	level := levelInfo
+	if code := status.Code(err); code == codes.Unavailable || code == codes.Internal || code == codes.Unknown {
+		level = levelError
+	}
+	logger.logf(level, "Request %v of %v failed: %v", call.requestID, method, err)
+}
+
+func (g *grpcLookupServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	callCtx, call, err := g.authorize(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, call.requestID))
+	if err != nil {
+		logCall(call, info.FullMethod, err)
+		return nil, err
+	}
+	if err := g.allow(call); err != nil {
+		grpc.SetTrailer(ctx, metadata.Pairs(grpcRetryAfterKey, err.(*throttledError).retryAfter()))
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	resp, err := handler(callCtx, req)
+	if err != nil {
+		logCall(call, info.FullMethod, err)
+	}
+	return resp, err
+}
+
+// callStream is a server stream with the context of its call
+type callStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (c *callStream) Context() context.Context {
+	return c.ctx
+}
+
+// streamInterceptor authorizes the streams. Their requests are rate limited
+// one by one.
+func (g *grpcLookupServer) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, call, err := g.authorize(stream.Context())
+	stream.SetHeader(metadata.Pairs(grpcRequestIDKey, call.requestID))
+	if err != nil {
+		logCall(call, info.FullMethod, err)
+		return err
+	}
+	if err := handler(srv, &callStream{ServerStream: stream, ctx: ctx}); err != nil {
+		logCall(call, info.FullMethod, err)
+		return err
+	}
+	return nil
+}
+
+// Convert the information of a URL as in the version 2 of the REST API
+func (g *grpcLookupServer) toProto(info *URLInfo) *urllookuppb.URLInfo {
+	info = g.s.infoV2(info)
+	return &urllookuppb.URLInfo{
+		Category:      info.Category,
+		Categories:    info.Categories,
+		CategoryPaths: info.CategoryPaths,
+		Safe:          info.Safe,
+		Found:         info.Found,
+		Rule:          info.Rule,
+		ThreatType:    info.ThreatType,
+		RiskScore:     int32(info.RiskScore),
+		Source:        info.Source,
+		FirstSeen:     info.FirstSeen,
+		LastSeen:      info.LastSeen,
+		Expires:       info.Expires,
+	}
+}
+
+// The URL of a request
+func requestURL(req *urllookuppb.LookupRequest) (*URL, error) {
+	query := &URLQuery{HostAndPort: req.Host, OriginalPath: req.Path, URL: req.Url}
+	return query.toURL()
+}
+
+// Look up URLs for a call, and log them in the access log
+func (g *grpcLookupServer) lookup(ctx context.Context, urls []URL) ([]*URLInfo, error) {
+	start := time.Now()
+	urlinfos, loaded, err := g.s.lookupLoaded(urls)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	call := callOf(ctx)
+	g.s.logLookups(call.client, call.requestID, start, urls, urlinfos, loaded)
+	return urlinfos, nil
+}
+
+// Lookup looks up a URL
+func (g *grpcLookupServer) Lookup(ctx context.Context, req *urllookuppb.LookupRequest) (*urllookuppb.LookupResponse, error) {
+	url, err := requestURL(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	urlinfos, err := g.lookup(ctx, []URL{*url})
+	if err != nil {
+		return nil, err
+	}
+	return &urllookuppb.LookupResponse{Id: req.Id, Info: g.toProto(urlinfos[0])}, nil
+}
+
+// BatchLookup looks up URLs at once
+func (g *grpcLookupServer) BatchLookup(ctx context.Context, req *urllookuppb.BatchLookupRequest) (*urllookuppb.BatchLookupResponse, error) {
+	if len(req.Urls) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch of %v urls exceeds the maximum of %v",
+			len(req.Urls), maxBatchSize)
+	}
+	urls := make([]URL, len(req.Urls))
+	for i := range req.Urls {
+		url, err := requestURL(req.Urls[i])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "url %v: %v", i, err)
+		}
+		urls[i] = *url
+	}
+	urlinfos, err := g.lookup(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	resp := &urllookuppb.BatchLookupResponse{Infos: make([]*urllookuppb.URLInfo, len(urlinfos))}
+	for i := range urlinfos {
+		resp.Infos[i] = g.toProto(urlinfos[i])
+	}
+	return resp, nil
+}
+
+// LookupStream looks up the URL of every request on a stream. The invalid
+// requests and the ones over the rate limit are answered with an error, while
+// a failure of the store ends the stream.
+func (g *grpcLookupServer) LookupStream(stream urllookuppb.URLLookup_LookupStreamServer) error {
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp := &urllookuppb.LookupResponse{Id: req.Id}
+		if err := g.allow(callOf(ctx)); err != nil {
+			resp.Error = err.Error()
+		} else if url, err := requestURL(req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			urlinfos, err := g.lookup(ctx, []URL{*url})
+			if err != nil {
+				return err
+			}
+			resp.Info = g.toProto(urlinfos[0])
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/baodongli/url-lookup/urllookuppb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Serve the gRPC API of a server on an in-process listener, and return a
+// connection to it and the function to stop them
+func startGRPC(t *testing.T, server *urlLookupServer) (*grpc.ClientConn, func()) {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := server.newGRPCServer(nil)
+	go grpcServer.Serve(listener)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect to the gRPC server: %v\n", err)
+	}
+	return conn, func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+}
+
+// Test the lookups of the gRPC API
+func TestGRPCLookup(t *testing.T) {
+	server := &urlLookupServer{store: newMemStore()}
+	news := URL{"www.cnn.com:80", "news"}
+	server.store.Put(news, &URLInfo{Category: "news", Safe: true, Verdict: Verdict{Source: "feed"}})
+	conn, stop := startGRPC(t, server)
+	defer stop()
+	client := urllookuppb.NewURLLookupClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var header metadata.MD
+	resp, err := client.Lookup(metadata.AppendToOutgoingContext(ctx, "x-request-id", "lookup-1"),
+		&urllookuppb.LookupRequest{Host: "WWW.CNN.COM", Path: "news", Id: "1"}, grpc.Header(&header))
+	if err != nil || resp.Id != "1" || resp.Info.Category != "news" || !resp.Info.Safe || !resp.Info.Found ||
+		resp.Info.Source != "feed" || resp.Info.Rule != "www.cnn.com:80/news" {
+		t.Errorf("Lookup returned %v, %v\n", resp, err)
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "lookup-1" {
+		t.Errorf("Lookup returned request IDs %v\n", ids)
+	}
+	if _, err := client.Lookup(ctx, &urllookuppb.LookupRequest{Path: "news"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Lookup of a URL without a host returned %v\n", err)
+	}
+
+	batch, err := client.BatchLookup(ctx, &urllookuppb.BatchLookupRequest{Urls: []*urllookuppb.LookupRequest{
+		{Url: "http://www.cnn.com/news"},
+		{Host: "www.unknown.com", Path: "index.html"},
+	}})
+	if err != nil || len(batch.Infos) != 2 || batch.Infos[0].Category != "news" || batch.Infos[1].Found {
+		t.Errorf("BatchLookup returned %v, %v\n", batch, err)
+	}
+	tooLarge := &urllookuppb.BatchLookupRequest{Urls: make([]*urllookuppb.LookupRequest, maxBatchSize+1)}
+	if _, err := client.BatchLookup(ctx, tooLarge); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchLookup of too many URLs returned %v\n", err)
+	}
+
+	stream, err := client.LookupStream(ctx)
+	if err != nil {
+		t.Errorf("Failed to open a stream: %v\n", err)
+		return
+	}
+	requests := []*urllookuppb.LookupRequest{
+		{Host: "www.cnn.com", Path: "news", Id: "a"},
+		{Url: "http://", Id: "b"},
+		{Url: "http://www.unknown.com/", Id: "c"},
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			t.Errorf("Failed to send %v: %v\n", req, err)
+		}
+	}
+	stream.CloseSend()
+	responses := []*urllookuppb.LookupResponse{}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("Failed to receive: %v\n", err)
+			break
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) != 3 || responses[0].Id != "a" || responses[0].Info.Category != "news" ||
+		responses[1].Id != "b" || responses[1].Error == "" || responses[1].Info != nil ||
+		responses[2].Id != "c" || responses[2].Info.Found {
+		t.Errorf("LookupStream returned %v\n", responses)
+	}
+}
+
+// Test that the gRPC API needs the API keys, and is rate limited
+func TestGRPCAuth(t *testing.T) {
+	keys := &apiKeys{digests: map[[sha256.Size]byte]bool{sha256.Sum256([]byte("key1")): true}}
+	limiter, err := newRateLimiter(&rateLimitOptions{rate: 0.1, burst: 1})
+	if err != nil {
+		t.Errorf("Failed to create the rate limiter: %v\n", err)
+		return
+	}
+	server := &urlLookupServer{store: newMemStore(), apiKeys: keys, rateLimiter: limiter}
+	conn, stop := startGRPC(t, server)
+	defer stop()
+	client := urllookuppb.NewURLLookupClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := &urllookuppb.LookupRequest{Host: "www.cnn.com", Path: "news"}
+
+	for _, key := range []string{"", "wrong"} {
+		keyCtx := metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+		if _, err := client.Lookup(keyCtx, req); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Lookup with key '%v' returned %v\n", key, err)
+		}
+	}
+	keyCtx := metadata.AppendToOutgoingContext(ctx, "x-api-key", "key1")
+	if _, err := client.Lookup(keyCtx, req); err != nil {
+		t.Errorf("Lookup with a key failed: %v\n", err)
+	}
+	var trailer metadata.MD
+	if _, err := client.Lookup(keyCtx, req, grpc.Trailer(&trailer)); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Lookup over the rate limit returned %v\n", err)
+	}
+	if retry := trailer.Get("retry-after"); len(retry) != 1 || retry[0] != "10" {
+		t.Errorf("Lookup over the rate limit returned retry after %v\n", retry)
+	}
+
+	// The requests of a stream are rate limited one by one
+	stream, err := client.LookupStream(keyCtx)
+	if err != nil {
+		t.Errorf("Failed to open a stream: %v\n", err)
+		return
+	}
+	stream.Send(req)
+	if resp, err := stream.Recv(); err != nil || resp.Error == "" {
+		t.Errorf("LookupStream over the rate limit returned %v, %v\n", resp, err)
+	}
+	stream.CloseSend()
+}
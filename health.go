@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// HealthInfo is the outcome of the liveness checks, by check
+type HealthInfo struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]string `json:"checks"`
+}
+
+// ReadinessInfo tells if the server is ready, and what it has loaded
+type ReadinessInfo struct {
+	Ready bool `json:"ready"`
+	// Version of the current snapshot
+	Version int `json:"version,omitempty"`
+	URLs    int `json:"urls"`
+	Rules   int `json:"rules"`
+	// Error is why the configuration files failed to be loaded
+	Error string `json:"error,omitempty"`
+}
+
+const healthOK = "ok"
+
+// Check that a file can be created in the cache path
+func checkWritable(path string) error {
+	if path == "" {
+		path = "."
+	}
+	file, err := ioutil.TempFile(path, ".healthz")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (s *urlLookupServer) health() *HealthInfo {
+	health := &HealthInfo{Healthy: true, Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		health.Checks[name] = healthOK
+		if err != nil {
+			health.Healthy = false
+			health.Checks[name] = err.Error()
+		}
+	}
+
+	var err error
+	if !s.isWatching() {
+		err = fmt.Errorf("not watching %v", s.urlCfgPath)
+	}
+	check("watcher", err)
+	if s.store.Stats().Backend != memoryBackend {
+		check("cache-path", checkWritable(s.urlCachePath))
+	}
+	return health
+}
+
+// readiness tells if the configuration files have been loaded
+func (s *urlLookupServer) readiness() *ReadinessInfo {
+	s.reloadLock.Lock()
+	ready, loadErr := s.loaded && !s.draining, s.loadErr
+	s.reloadLock.Unlock()
+
+	readiness := &ReadinessInfo{Ready: ready}
+	if loadErr != nil {
+		readiness.Error = loadErr.Error()
+	}
+	if current := s.snapshots().Current; ready && current != nil {
+		readiness.Version, readiness.URLs, readiness.Rules = current.Version, current.URLs, current.Rules
+	}
+	return readiness
+}
+
+func (s *urlLookupServer) getHealth(request *restful.Request, response *restful.Response) {
+	health := s.health()
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	if err := response.WriteHeaderAndEntity(status, health); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func (s *urlLookupServer) getReadiness(request *restful.Request, response *restful.Response) {
+	readiness := s.readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	if err := response.WriteHeaderAndEntity(status, readiness); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// Serve a GET request with a route function, and decode the response
+func serveGet(route restful.RouteFunction, entity interface{}) int {
+	ws := &restful.WebService{}
+	ws.Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("/").To(route))
+	container := restful.NewContainer()
+	container.Add(ws)
+
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	json.Unmarshal(recorder.Body.Bytes(), entity)
+	return recorder.Code
+}
+
+// Test that the server is ready only once the configuration files are loaded,
+// and that the watcher retries the initial load
+func TestReadiness(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	cfg := filepath.Join(urlCfgPath, "urlcfg1.json")
+	if err := ioutil.WriteFile(cfg, []byte(`{"urls":[`), 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", cfg, err)
+	}
+
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: newMemStore()}
+	readiness := &ReadinessInfo{}
+	if status := serveGet(server.getReadiness, readiness); status != http.StatusServiceUnavailable || readiness.Ready {
+		t.Errorf("Ready before loading: %v, %v\n", status, *readiness)
+	}
+	if err := server.loadURLs(); err == nil {
+		t.Errorf("Loaded an invalid file\n")
+	}
+	readiness = &ReadinessInfo{}
+	if status := serveGet(server.getReadiness, readiness); status != http.StatusServiceUnavailable || readiness.Error == "" {
+		t.Errorf("Ready after failing to load: %v, %v\n", status, *readiness)
+	}
+
+	if err := server.watchForUpdate(); err != nil {
+		t.Errorf("Failed to watch %v: %v\n", urlCfgPath, err)
+		return
+	}
+	writeURLs(t, cfg, entries1.URLEntries...)
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.isLoaded() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	readiness = &ReadinessInfo{}
+	if status := serveGet(server.getReadiness, readiness); status != http.StatusOK || !readiness.Ready ||
+		readiness.URLs != len(entries1.URLEntries) || readiness.Version == 0 || readiness.Error != "" {
+		t.Errorf("Not ready after loading: %v, %v\n", status, *readiness)
+	}
+}
+
+// Test the liveness checks of the watcher and the cache path
+func TestHealth(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: urlCachePath, store: newBucketStore(urlCachePath, 31, 100)}
+	health := &HealthInfo{}
+	if status := serveGet(server.getHealth, health); status != http.StatusServiceUnavailable || health.Checks["watcher"] == healthOK {
+		t.Errorf("Healthy without a watcher: %v, %v\n", status, *health)
+	}
+
+	if err := server.watchForUpdate(); err != nil {
+		t.Errorf("Failed to watch %v: %v\n", urlCfgPath, err)
+		return
+	}
+	health = &HealthInfo{}
+	if status := serveGet(server.getHealth, health); status != http.StatusOK || !health.Healthy {
+		t.Errorf("Not healthy: %v, %v\n", status, *health)
+	}
+
+	server.urlCachePath = filepath.Join(urlCachePath, "gone")
+	health = &HealthInfo{}
+	if status := serveGet(server.getHealth, health); status != http.StatusServiceUnavailable || health.Checks["cache-path"] == healthOK {
+		t.Errorf("Healthy with an unwritable cache path: %v, %v\n", status, *health)
+	}
+}
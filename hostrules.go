@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	// A path that matches every path on a host
+	allPaths = "*"
+	// A host prefix that matches all the subdomains of a domain
+	wildcardPrefix = "*."
+)
+
+// pathTrie is a trie of path segments in which a node has the information of
+// the prefix rule ending at it
+type pathTrie struct {
+	info     *URLInfo
+	children map[string]*pathTrie
+}
+
+// hostRules indexes the rules that apply to all the paths of a host, or of all
+// the subdomains of a domain such as *.example.com:80, and the path prefix rules
+// of each host. They are not hashed into the buckets and always stay in memory.
+type hostRules struct {
+	lock     sync.RWMutex
+	rules    map[string]*URLInfo
+	prefixes map[string]*pathTrie
+}
+
+// Split a path into its segments, ignoring the query string
+func pathSegments(path string) []string {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// Render a rule that matches every path under a path prefix of a host, or every
+// path of the host with an empty prefix, as host/prefix/*
+func ruleString(hostAndPort, prefix string) string {
+	if segments := pathSegments(prefix); len(segments) > 0 {
+		return hostAndPort + "/" + strings.Join(segments, "/") + "/" + allPaths
+	}
+	return hostAndPort + "/" + allPaths
+}
+
+func isHostRule(url *URL) bool {
+	return url.originalPath == allPaths
+}
+
+func (r *hostRules) add(hostAndPort string, info *URLInfo) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.rules == nil {
+		r.rules = make(map[string]*URLInfo)
+	}
+	r.rules[hostAndPort] = info
+}
+
+func (r *hostRules) addPrefix(hostAndPort, prefix string, info *URLInfo) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.prefixes == nil {
+		r.prefixes = make(map[string]*pathTrie)
+	}
+	node := r.prefixes[hostAndPort]
+	if node == nil {
+		node = &pathTrie{}
+		r.prefixes[hostAndPort] = node
+	}
+	for _, segment := range pathSegments(prefix) {
+		child := node.children[segment]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*pathTrie)
+			}
+			child = &pathTrie{}
+			node.children[segment] = child
+		}
+		node = child
+	}
+	node.info = info
+}
+
+// Find the longest prefix rule of the host that matches the path. It returns
+// the matched prefix and its information.
+func (r *hostRules) matchPrefix(hostAndPort, path string) (string, *URLInfo) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	node := r.prefixes[hostAndPort]
+	if node == nil {
+		return "", nil
+	}
+
+	segments := pathSegments(path)
+	prefix, info := "", node.info
+	for i, segment := range segments {
+		if node = node.children[segment]; node == nil {
+			break
+		}
+		if node.info != nil {
+			prefix, info = strings.Join(segments[:i+1], "/"), node.info
+		}
+	}
+	return prefix, info
+}
+
+// Find the rule for the host itself, then for its parent domains from the
+// closest one. It returns the matched rule and its information.
+func (r *hostRules) match(hostAndPort string) (string, *URLInfo) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.rules) == 0 {
+		return "", nil
+	}
+	if info, ok := r.rules[hostAndPort]; ok {
+		return hostAndPort, info
+	}
+
+	host, port, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		host, port = hostAndPort, ""
+	}
+	for domain := host; ; {
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return "", nil
+		}
+		domain = domain[dot+1:]
+		pattern := wildcardPrefix + domain
+		if port != "" {
+			pattern = net.JoinHostPort(pattern, port)
+		}
+		if info, ok := r.rules[pattern]; ok {
+			return pattern, info
+		}
+	}
+}
+
+// Look up a URL that is not in the bucket of its host in the path prefix rules
+// of the host, and then in the host rules of the current snapshot. The rules
+// expired at now don't match. Callers hold snapshotLock.
+func (s *urlLookupServer) matchHost(url URL, now string) *URLInfo {
+	if s.current == nil {
+		return notFound
+	}
+	prefix, info := s.current.rules.matchPrefix(url.hostAndPort, url.originalPath)
+	if info != nil && info.expired(now) {
+		expiredLookupsTotal.inc()
+		info = nil
+	}
+	if info != nil {
+		matched := *info
+		matched.Found = true
+		matched.Rule = ruleString(url.hostAndPort, prefix)
+		return &matched
+	}
+
+	rule, info := s.current.rules.match(url.hostAndPort)
+	if info != nil && info.expired(now) {
+		expiredLookupsTotal.inc()
+		info = nil
+	}
+	if info == nil {
+		return notFound
+	}
+	matched := *info
+	matched.Found = true
+	matched.Rule = ruleString(rule, "")
+	return &matched
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test the fallback from exact urls to host and wildcard subdomain rules
+func TestMatchHost(t *testing.T) {
+	server := &urlLookupServer{
+		httpPort:     16888,
+		urlCachePath: "",
+		store:        newMemStore(),
+	}
+	entries := []URLDBEntry{
+		{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)},
+		{HostAndPort: "www.cnn.com:80", OriginalPath: "*", Category: "media", Safe: boolPtr(true)},
+		{HostAndPort: "*.evil.com:80", OriginalPath: "*", Category: "malware", Safe: boolPtr(false)},
+		{HostAndPort: "*.cdn.evil.com:80", OriginalPath: "*", Category: "phishing", Safe: boolPtr(false)},
+	}
+	for i := range entries {
+		if err := server.addEntry(&entries[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entries[i], err)
+		}
+	}
+
+	tests := []struct {
+		url      URL
+		category string
+		rule     string
+	}{
+		{URL{"www.cnn.com:80", "news"}, "news", "www.cnn.com:80/news"},
+		{URL{"www.cnn.com:80", "sports"}, "media", "www.cnn.com:80/*"},
+		{URL{"a.evil.com:80", "index.html"}, "malware", "*.evil.com:80/*"},
+		{URL{"a.b.evil.com:80", "index.html"}, "malware", "*.evil.com:80/*"},
+		{URL{"x.cdn.evil.com:80", "login"}, "phishing", "*.cdn.evil.com:80/*"},
+		{URL{"evil.com:80", "index.html"}, notFound.Category, ""},
+		{URL{"a.evil.com:443", "index.html"}, notFound.Category, ""},
+		{URL{"cnn.com:80", "news"}, notFound.Category, ""},
+	}
+	for _, test := range tests {
+		urlinfos, err := server.lookup([]URL{test.url})
+		if err != nil {
+			t.Errorf("Failed to look up %v: %v\n", test.url, err)
+			continue
+		}
+		if urlinfos[0].Category != test.category || urlinfos[0].Rule != test.rule {
+			t.Errorf("Looked up %v: %v, expected category %v by rule %v\n",
+				test.url, *urlinfos[0], test.category, test.rule)
+		}
+	}
+}
+
+// Test the longest path prefix match and the precedence of exact urls
+func TestMatchPrefix(t *testing.T) {
+	server := &urlLookupServer{
+		httpPort:     16888,
+		urlCachePath: "",
+		store:        newMemStore(),
+	}
+	entries := []URLDBEntry{
+		{HostAndPort: "www.files.com:80", OriginalPath: "downloads", Match: matchPrefix, Category: "downloads", Safe: boolPtr(true)},
+		{HostAndPort: "www.files.com:80", OriginalPath: "downloads/warez/", Match: matchPrefix, Category: "piracy", Safe: boolPtr(false)},
+		{HostAndPort: "www.files.com:80", OriginalPath: "downloads/warez/readme.txt", Match: matchExact, Category: "text", Safe: boolPtr(true)},
+		{HostAndPort: "www.files.com:80", OriginalPath: "*", Category: "storage", Safe: boolPtr(true)},
+	}
+	for i := range entries {
+		if err := server.addEntry(&entries[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entries[i], err)
+		}
+	}
+	if err := server.addEntry(&URLDBEntry{HostAndPort: "www.files.com:80", Match: "regex"}); err == nil {
+		t.Errorf("Adding an entry with an unknown match should fail\n")
+	}
+
+	tests := []struct {
+		path     string
+		category string
+		rule     string
+	}{
+		{"downloads", "downloads", "www.files.com:80/downloads/*"},
+		{"downloads/evil.exe", "downloads", "www.files.com:80/downloads/*"},
+		{"downloads/warez/crack.exe?id=1", "piracy", "www.files.com:80/downloads/warez/*"},
+		{"downloads/warez/readme.txt", "text", "www.files.com:80/downloads/warez/readme.txt"},
+		{"downloadsX/evil.exe", "storage", "www.files.com:80/*"},
+	}
+	for _, test := range tests {
+		url := URL{hostAndPort: "www.files.com:80", originalPath: test.path}
+		urlinfos, err := server.lookup([]URL{url})
+		if err != nil {
+			t.Errorf("Failed to look up %v: %v\n", url, err)
+			continue
+		}
+		if urlinfos[0].Category != test.category || urlinfos[0].Rule != test.rule {
+			t.Errorf("Looked up %v: %v, expected category %v by rule %v\n",
+				url, *urlinfos[0], test.category, test.rule)
+		}
+	}
+
+	// A prefix record is named like the rule it matches by
+	key := entryKey{url: URL{hostAndPort: "www.files.com:80", originalPath: "downloads/warez/"}, prefix: true}
+	if key.String() != "www.files.com:80/downloads/warez/*" {
+		t.Errorf("Prefix record named %v, expected www.files.com:80/downloads/warez/*\n", key)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The journal of the url records changed with the admin API, in the cache path
+const journalFile = "admin-journal.jsonl"
+
+// Operations of the journal
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+)
+
+// JournalOp is a change of a url record, one per line of the journal
+type JournalOp struct {
+	Op   string    `json:"op"`
+	Time time.Time `json:"time"`
+	// Record is the record put, or the host, path and match of the one deleted
+	Record URLDBEntry `json:"record"`
+}
+
+// journal is an append-only file of the changes of the url records. Every
+// change is synced to the disk before it's acknowledged. The journal is
+// compacted into the puts of the records it ends up with, and the deletes of
+// the records of the files, once it has grown well beyond them.
+type journal struct {
+	fileName string
+	file     *os.File
+	// ops is the number of changes in the file
+	ops int
+}
+
+// openJournal opens the journal in a directory, creating it if needed, and
+// returns its changes. A torn last line, from a crash in the middle of a write,
+// is dropped.
+func openJournal(dir string) (*journal, []JournalOp, error) {
+	fileName := filepath.Join(dir, journalFile)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	ops := []JournalOp{}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var op JournalOp
+		if err := json.Unmarshal(line, &op); err != nil {
+			if i == len(lines)-1 {
+				logger.warnf("Dropped the torn last change of %v", fileName)
+				break
+			}
+			return nil, nil, fmt.Errorf("%s: line %v: %v", fileName, i+1, err)
+		}
+		ops = append(ops, op)
+	}
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.infof("Read %v changes from %s", len(ops), fileName)
+	return &journal{fileName: fileName, file: file, ops: len(ops)}, ops, nil
+}
+
+// append writes a change to the journal and syncs it
+func (j *journal) append(op *JournalOp) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.ops++
+	return nil
+}
+
+// compact rewrites the journal atomically as the puts of records and the
+// deletes of deleted, if it has grown beyond twice as many changes, or if force
+// is set
+func (j *journal) compact(records, deleted []URLDBEntry, force bool) error {
+	if !force && j.ops <= 2*(len(records)+len(deleted))+64 {
+		return nil
+	}
+	var buf bytes.Buffer
+	now := time.Now().UTC()
+	for _, change := range []struct {
+		op      string
+		records []URLDBEntry
+	}{{journalPut, records}, {journalDelete, deleted}} {
+		for i := range change.records {
+			data, err := json.Marshal(&JournalOp{Op: change.op, Time: now, Record: change.records[i]})
+			if err != nil {
+				return err
+			}
+			buf.Write(append(data, '\n'))
+		}
+	}
+	if err := writeFileAtomic(j.fileName, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// Append to the new file from now on
+	file, err := os.OpenFile(j.fileName, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file, j.ops = file, len(records)+len(deleted)
+	logger.infof("Compacted %s into %v records and %v deletes", j.fileName, len(records), len(deleted))
+	return nil
+}
+
+// Close closes the journal file
+func (j *journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A line of the journal
+func journalLine(t *testing.T, op string, record URLDBEntry) string {
+	data, err := json.Marshal(&JournalOp{Op: op, Time: time.Now().UTC(), Record: record})
+	if err != nil {
+		t.Fatalf("Failed to marshal %v: %v\n", record, err)
+	}
+	return string(data) + "\n"
+}
+
+// Open a journal with content in a tmp dir
+func openTestJournal(t *testing.T, content string) (*journal, []JournalOp, string, error) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Failed to create tmp dir: %v\n", err)
+	}
+	if content != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, journalFile), []byte(content), 0666); err != nil {
+			t.Fatalf("Failed to write the journal: %v\n", err)
+		}
+	}
+	j, ops, err := openJournal(dir)
+	return j, ops, dir, err
+}
+
+// Test that a torn last line of the journal is dropped, while a corrupted line
+// before it fails the journal
+func TestOpenJournal(t *testing.T) {
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news"}
+	put := journalLine(t, journalPut, news)
+	tests := []struct {
+		name    string
+		content string
+		ops     int
+		fail    bool
+	}{
+		{"missing file", "", 0, false},
+		{"one change", put, 1, false},
+		{"blank lines", put + "\n\n" + put, 2, false},
+		{"truncated final line", put + put[:len(put)/2], 1, false},
+		{"truncated line before the last", put[:len(put)/2] + "\n" + put, 0, true},
+	}
+	for _, test := range tests {
+		j, ops, dir, err := openTestJournal(t, test.content)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Opened a journal with a %v\n", test.name)
+				j.Close()
+			}
+		} else if err != nil || len(ops) != test.ops || j.ops != test.ops {
+			t.Errorf("Opened a journal with a %v: %v changes, %v, expected %v\n", test.name, len(ops), err, test.ops)
+		} else {
+			j.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Test the records and the deletions that the changes of the journal end up
+// with, and that they survive a compaction
+func TestReplayJournal(t *testing.T) {
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news"}
+	fake := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "fake-news"}
+	evil := URLDBEntry{HostAndPort: "www.evil.com:80", OriginalPath: "login", Category: "phishing", Match: matchPrefix}
+	newsKey := keyRecord(entryKey{url: URL{"www.cnn.com:80", "news"}})
+	evilKey := keyRecord(entryKey{url: URL{"www.evil.com:80", "login"}, prefix: true})
+	tests := []struct {
+		name    string
+		ops     []JournalOp
+		added   []URLDBEntry
+		deleted []URLDBEntry
+	}{
+		{"put", []JournalOp{{Op: journalPut, Record: news}}, []URLDBEntry{news}, []URLDBEntry{}},
+		{"put after a put", []JournalOp{{Op: journalPut, Record: news}, {Op: journalPut, Record: fake}},
+			[]URLDBEntry{fake}, []URLDBEntry{}},
+		{"delete after a put", []JournalOp{{Op: journalPut, Record: news}, {Op: journalDelete, Record: newsKey}},
+			[]URLDBEntry{}, []URLDBEntry{}},
+		{"delete", []JournalOp{{Op: journalDelete, Record: news}}, []URLDBEntry{}, []URLDBEntry{newsKey}},
+		{"put after a delete", []JournalOp{{Op: journalDelete, Record: news}, {Op: journalPut, Record: fake}},
+			[]URLDBEntry{fake}, []URLDBEntry{}},
+		{"delete, put and delete", []JournalOp{{Op: journalDelete, Record: evil}, {Op: journalPut, Record: evil},
+			{Op: journalDelete, Record: evil}}, []URLDBEntry{}, []URLDBEntry{}},
+		{"delete of a prefix rule", []JournalOp{{Op: journalPut, Record: news}, {Op: journalDelete, Record: evil}},
+			[]URLDBEntry{news}, []URLDBEntry{evilKey}},
+		{"unknown op", []JournalOp{{Op: "patch", Record: news}}, []URLDBEntry{}, []URLDBEntry{}},
+	}
+	for _, test := range tests {
+		content := ""
+		for _, op := range test.ops {
+			content += journalLine(t, op.Op, op.Record)
+		}
+		// The journal is compacted when it's replayed, and replayed again
+		// after the compaction
+		j, ops, dir, err := openTestJournal(t, content)
+		for restart := 0; restart < 2 && err == nil; restart++ {
+			server := &urlLookupServer{store: newMemStore(), journal: j}
+			if err = server.replayJournal(ops); err != nil {
+				t.Errorf("Failed to replay the journal of a %v: %v\n", test.name, err)
+				break
+			}
+			if added, deleted := server.addedRecords(), server.deletedRecords(); !reflect.DeepEqual(added, test.added) ||
+				!reflect.DeepEqual(deleted, test.deleted) {
+				t.Errorf("Replayed %v and deleted %v from a %v after %v restarts, expected %v and %v\n",
+					added, deleted, test.name, restart, test.added, test.deleted)
+			}
+			if j.ops != len(test.added)+len(test.deleted) {
+				t.Errorf("Compacted a %v into %v changes\n", test.name, j.ops)
+			}
+			j.Close()
+			j, ops, err = openJournal(dir)
+		}
+		if err != nil {
+			t.Errorf("Failed to open the journal of a %v: %v\n", test.name, err)
+		} else {
+			j.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Test that the journal is compacted only once it has grown well beyond its
+// records, and that it's appended to after a compaction
+func TestCompactJournal(t *testing.T) {
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news"}
+	deleted := []URLDBEntry{{HostAndPort: "www.evil.com:80", OriginalPath: "login"}}
+	j, _, dir, err := openTestJournal(t, strings.Repeat(journalLine(t, journalPut, news), 67))
+	if err != nil {
+		t.Errorf("Failed to open the journal: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		records []URLDBEntry
+		force   bool
+		ops     int
+	}{
+		{[]URLDBEntry{news}, false, 67},
+		{[]URLDBEntry{}, false, 1},
+		{[]URLDBEntry{news}, true, 2},
+	}
+	for i, test := range tests {
+		if err := j.compact(test.records, deleted, test.force); err != nil || j.ops != test.ops {
+			t.Errorf("Compaction %v left %v changes, %v, expected %v\n", i, j.ops, err, test.ops)
+		}
+	}
+	if err := j.append(&JournalOp{Op: journalDelete, Record: news}); err != nil {
+		t.Errorf("Failed to append after a compaction: %v\n", err)
+	}
+	j.Close()
+	if j, ops, err := openJournal(dir); err != nil || len(ops) != 3 || ops[0].Op != journalPut ||
+		ops[1].Op != journalDelete || ops[2].Record.OriginalPath != "news" {
+		t.Errorf("Reopened the compacted journal with %v, %v\n", ops, err)
+	} else {
+		j.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the log
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevel is the severity of a log record
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+// Parse the name of a level
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(level), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level '%v'", name)
+}
+
+// logOptions defines the level and the format of the log, and the access log
+type logOptions struct {
+	level  string
+	format string
+	// File of the access log, which is disabled without one, or - for the
+	// standard output
+	accessLogFile string
+	// Size in MB that the access log is rotated at, and the number of rotated
+	// files kept
+	accessLogMaxSize    int
+	accessLogMaxBackups int
+}
+
+// LogRecord is a record of the log in the JSON format
+type LogRecord struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// leveledLogger writes the records at or above its level, as lines of text or
+// JSON
+type leveledLogger struct {
+	lock  sync.Mutex
+	out   io.Writer
+	level logLevel
+	json  bool
+}
+
+// logger is the log of the service, which goes to the standard error
+var logger = &leveledLogger{out: os.Stderr, level: levelInfo}
+
+// configure sets the level and the format of the log. The standard log, which
+// the libraries use, is written to it at the info level.
+func (l *leveledLogger) configure(level, format string) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("unknown log format '%v'", format)
+	}
+	l.lock.Lock()
+	l.level, l.json = parsed, format == logFormatJSON
+	l.lock.Unlock()
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{l})
+	return nil
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if level < l.level {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	if l.json {
+		line, _ := json.Marshal(&LogRecord{Time: now, Level: level.String(), Msg: msg})
+		l.out.Write(append(line, '\n'))
+		return
+	}
+	fmt.Fprintf(l.out, "%s %-5s %s\n", now, strings.ToUpper(level.String()), msg)
+}
+
+func (l *leveledLogger) debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+func (l *leveledLogger) infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l *leveledLogger) warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+func (l *leveledLogger) errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+// stdLogWriter writes the lines of the standard log to a logger
+type stdLogWriter struct {
+	logger *leveledLogger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.logger.infof("%s", line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"container/list"
+)
+
+type lruEntry struct {
+	url  URL
+	info *URLInfo
+}
+
+// lruCache keeps the most recently used URLs up to its capacity. It is not
+// safe for concurrent use.
+type lruCache struct {
+	capacity int
+	ll       *list.List
+	items    map[URL]*list.Element
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[URL]*list.Element),
+	}
+}
+
+func (c *lruCache) get(url URL) (*URLInfo, bool) {
+	elem, ok := c.items[url]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*lruEntry).info, true
+}
+
+// Add or replace a URL, and return the least recently used one if it's evicted
+func (c *lruCache) add(url URL, info *URLInfo) *lruEntry {
+	if elem, ok := c.items[url]; ok {
+		elem.Value.(*lruEntry).info = info
+		c.ll.MoveToFront(elem)
+		return nil
+	}
+	c.items[url] = c.ll.PushFront(&lruEntry{url: url, info: info})
+	if c.ll.Len() <= c.capacity {
+		return nil
+	}
+
+	oldest := c.ll.Back()
+	c.ll.Remove(oldest)
+	entry := oldest.Value.(*lruEntry)
+	delete(c.items, entry.url)
+	return entry
+}
+
+func (c *lruCache) remove(url URL) {
+	if elem, ok := c.items[url]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, url)
+	}
+}
+
+func (c *lruCache) len() int {
+	return c.ll.Len()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	a, b, d := URL{"a.com:80", "a"}, URL{"b.com:80", "b"}, URL{"d.com:80", "d"}
+	c.add(a, &URLInfo{Category: "a"})
+	c.add(b, &URLInfo{Category: "b"})
+
+	// a becomes the most recently used, so b is evicted
+	if _, ok := c.get(a); !ok {
+		t.Errorf("%v is not cached\n", a)
+	}
+	if evicted := c.add(d, &URLInfo{Category: "d"}); evicted == nil || evicted.url != b {
+		t.Errorf("Unexpected eviction %v, expected %v\n", evicted, b)
+	}
+	if _, ok := c.get(b); ok {
+		t.Errorf("%v has not been evicted\n", b)
+	}
+
+	c.remove(a)
+	if _, ok := c.get(a); ok || c.len() != 1 {
+		t.Errorf("%v has not been removed\n", a)
+	}
+}
@@ -2,17 +2,28 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	httpPort     int
-	urlCfgPath   string
-	urlCachePath string
+	httpPort      int
+	grpcPort      int
+	urlCfgPath    string
+	urlCachePath  string
+	taxonomyPath  string
+	authOpts      authOptions
+	rateOpts      rateLimitOptions
+	extAuthzOpts  extAuthzOptions
+	logOpts       logOptions
+	storeOpts     storeOptions
+	sweepInterval time.Duration
+	drainTimeout  time.Duration
 
 	lookupCmd = &cobra.Command{
 		Use:   "url-lookup",
@@ -30,10 +41,19 @@ var (
 				os.Exit(-1)
 			}
 
+			if err := logger.configure(logOpts.level, logOpts.format); err != nil {
+				return err
+			}
+
 			stop := make(chan struct{})
-			err := newLookupServer(httpPort, urlCfgPath, urlCachePath, stop)
+			done, err := newLookupServer(httpPort, grpcPort, urlCfgPath, urlCachePath, taxonomyPath, &storeOpts, &authOpts,
+				&rateOpts, &logOpts, &extAuthzOpts, sweepInterval, drainTimeout, stop)
+			if err != nil {
+				return err
+			}
 			waitSignal(stop)
-			return err
+			<-done
+			return nil
 		},
 	}
 )
@@ -42,13 +62,56 @@ func waitSignal(stop chan struct{}) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
+	signal.Stop(sigs)
 	close(stop)
 }
 
 func init() {
 	lookupCmd.PersistentFlags().IntVar(&httpPort, "port", 16888, "URL lookup service port")
+	lookupCmd.PersistentFlags().IntVar(&grpcPort, "grpc-port", 0, "Port of the gRPC lookup API, disabled if 0")
 	lookupCmd.PersistentFlags().StringVar(&urlCfgPath, "url-config-path", "", "URL configuration path")
 	lookupCmd.PersistentFlags().StringVar(&urlCachePath, "url-cache-path", "", "URL cache path")
+	lookupCmd.PersistentFlags().StringVar(&taxonomyPath, "taxonomy-file", "",
+		"Taxonomy of the URL categories, which are free-form without one")
+	lookupCmd.PersistentFlags().StringVar(&authOpts.adminTokenFile, "admin-token-file", "",
+		"File with the bearer token of the admin API, which is disabled without one")
+	lookupCmd.PersistentFlags().StringVar(&authOpts.certFile, "tls-cert-file", "",
+		"TLS certificate of the server, which serves plain http without one")
+	lookupCmd.PersistentFlags().StringVar(&authOpts.keyFile, "tls-key-file", "", "TLS key of the server")
+	lookupCmd.PersistentFlags().StringVar(&authOpts.clientCAFile, "tls-client-ca-file", "",
+		"CA bundle to verify the client certificates against, which are required if set")
+	lookupCmd.PersistentFlags().StringVar(&authOpts.apiKeysFile, "api-keys-file", "",
+		"File of the API keys, one per line, which are required in the X-API-Key header if set")
+	lookupCmd.PersistentFlags().Float64Var(&rateOpts.rate, "rate-limit", 0,
+		"Requests per second of a client, by API key or by IP without API keys, 0 to not limit them")
+	lookupCmd.PersistentFlags().IntVar(&rateOpts.burst, "rate-burst", 0,
+		"Requests a client can make at once, the rate limit rounded up if 0")
+	lookupCmd.PersistentFlags().StringVar(&rateOpts.limitsFile, "rate-limits-file", "",
+		"JSON file of the rate limits of given clients, which override --rate-limit and --rate-burst")
+	lookupCmd.PersistentFlags().StringVar(&storeOpts.backend, "url-store", bucketBackend,
+		"URL store backend, either 'bucket', 'memory' or 'bolt'")
+	lookupCmd.PersistentFlags().IntVar(&storeOpts.hashTableSize, "url-cache-buckets", 31, "Number of buckets in the URL cache")
+	lookupCmd.PersistentFlags().IntVar(&storeOpts.maxUrlsCached, "url-cache-capacity", 100, "Maximum number of URLs cached in memory")
+	lookupCmd.PersistentFlags().DurationVar(&sweepInterval, "sweep-interval", time.Minute,
+		"Interval to purge the expired URL records, 0 to never purge them")
+	lookupCmd.PersistentFlags().StringVar(&logOpts.level, "log-level", "info",
+		"Level of the log, either 'debug', 'info', 'warn' or 'error'")
+	lookupCmd.PersistentFlags().StringVar(&logOpts.format, "log-format", logFormatText,
+		"Format of the log, either 'text' or 'json'")
+	lookupCmd.PersistentFlags().StringVar(&logOpts.accessLogFile, "access-log-file", "",
+		"File of the JSON access log of the lookups and the admin changes, '-' for the standard output, disabled if empty")
+	lookupCmd.PersistentFlags().IntVar(&logOpts.accessLogMaxSize, "access-log-max-size", 100,
+		"Size in MB that the access log file is rotated at")
+	lookupCmd.PersistentFlags().IntVar(&logOpts.accessLogMaxBackups, "access-log-max-backups", 5,
+		"Number of rotated access log files kept")
+	lookupCmd.PersistentFlags().BoolVar(&extAuthzOpts.enabled, "ext-authz", false,
+		"Serve the Envoy external authorization API, over http under /ext_authz and over gRPC if --grpc-port is set")
+	lookupCmd.PersistentFlags().IntVar(&extAuthzOpts.deniedStatus, "ext-authz-denied-status", http.StatusForbidden,
+		"HTTP status that Envoy returns for the unsafe URLs")
+	lookupCmd.PersistentFlags().StringVar(&extAuthzOpts.deniedBody, "ext-authz-denied-body", "Access denied: the URL is unsafe\n",
+		"Body that Envoy returns for the unsafe URLs")
+	lookupCmd.PersistentFlags().DurationVar(&drainTimeout, "drain-timeout", 10*time.Second,
+		"Maximum time to drain the requests in flight when shutting down")
 	lookupCmd.MarkPersistentFlagRequired("url-config-path")
 	lookupCmd.MarkPersistentFlagRequired("url-cache-path")
 }
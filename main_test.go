@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Test that SIGTERM during load drains the requests in flight, stops the
+// watcher, and writes the dirty cached URLs to their bucket files
+func TestShutdownOnSIGTERM(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"), entries1.URLEntries...)
+
+	store := newBucketStore(urlCachePath, 5, 100)
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, urlCachePath: urlCachePath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+	if err := server.watchForUpdate(); err != nil {
+		t.Errorf("Failed to watch %v: %v\n", urlCfgPath, err)
+	}
+	dirty := URL{"www.dirty.com:80", "index.html"}
+	if err := store.Put(dirty, &URLInfo{Category: "dirty"}); err != nil {
+		t.Errorf("Failed to put %v: %v\n", dirty, err)
+	}
+
+	// A request with the slow header is in flight when the signal comes
+	container := server.newContainer()
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Slow") != "" {
+			close(started)
+			time.Sleep(500 * time.Millisecond)
+		}
+		container.ServeHTTP(w, r)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Failed to listen: %v\n", err)
+		return
+	}
+	base := fmt.Sprintf("http://%v/urlinfo/1/www.cnn.com:80/news", listener.Addr())
+	stop := make(chan struct{})
+	done := server.serve(listener, handler, 5*time.Second, stop)
+
+	// Keep SIGTERM from killing the test before waitSignal is notified of it
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGTERM)
+	defer signal.Stop(guard)
+	go waitSignal(stop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp, err := http.Get(base)
+				if err != nil {
+					// Refused once the server has stopped listening
+					continue
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("Unexpected status %v under load\n", resp.StatusCode)
+				}
+			}
+		}()
+	}
+
+	slow := make(chan *URLInfo)
+	go func() {
+		defer close(slow)
+		request, _ := http.NewRequest("GET", base, nil)
+		request.Header.Set("X-Slow", "true")
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("The request in flight failed: %v\n", err)
+			return
+		}
+		defer resp.Body.Close()
+		info := &URLInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+			t.Errorf("Failed to decode the response in flight: %v\n", err)
+			return
+		}
+		slow <- info
+	}()
+
+	<-started
+	for signaled := false; !signaled; {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		select {
+		case <-stop:
+			signaled = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if info := <-slow; info == nil || info.Category != "news" {
+		t.Errorf("Unexpected response in flight: %v\n", info)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Errorf("The server didn't shut down\n")
+		return
+	}
+	wg.Wait()
+
+	if _, err := http.Get(base); err == nil {
+		t.Errorf("The server still serves after shutting down\n")
+	}
+	if server.isWatching() {
+		t.Errorf("The watcher is still running\n")
+	}
+	urldb := make(URLDB)
+	if err := readBucketFile(store.urlht[hash(dirty.hostAndPort, len(store.urlht))].fileName, urldb); err != nil {
+		t.Errorf("Failed to read the bucket file: %v\n", err)
+	}
+	if info := urldb[dirty]; info == nil || info.Category != "dirty" {
+		t.Errorf("The dirty url %v has not been written to its bucket file\n", dirty)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+)
+
+const memoryBackend = "memory"
+
+// memStore keeps all the URLs in an unbounded map in memory
+type memStore struct {
+	lock  sync.RWMutex
+	urldb URLDB
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		urldb: make(URLDB),
+	}
+}
+
+// Get looks up URLs in the map
+func (s *memStore) Get(urls []URL) ([]*URLInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	urlinfos := make([]*URLInfo, len(urls))
+	for i, url := range urls {
+		urlinfos[i] = s.urldb[url]
+	}
+	return urlinfos, nil
+}
+
+// Put adds or replaces a URL
+func (s *memStore) Put(url URL, info *URLInfo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.urldb[url] = info
+	return nil
+}
+
+// Delete removes a URL
+func (s *memStore) Delete(url URL) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.urldb, url)
+	return nil
+}
+
+// Iterate calls fn with every URL. fn must not modify the store.
+func (s *memStore) Iterate(fn func(url URL, info *URLInfo) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for url, info := range s.urldb {
+		if err := fn(url, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of URLs
+func (s *memStore) Stats() *StoreStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &StoreStats{
+		Backend: memoryBackend,
+		Cached:  len(s.urldb),
+	}
+}
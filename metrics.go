@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// The content type of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default buckets of the latency histograms in seconds
+var latencyBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// Outcomes of the configuration reloads
+const (
+	reloadSucceeded = "success"
+	// The files or the snapshot are invalid
+	reloadRejected = "rejected"
+	// The store failed to be updated
+	reloadFailed = "failed"
+)
+
+var (
+	lookupsTotal = newCounter("urllookup_lookups_total",
+		"URLs looked up by verdict and category.", "verdict", "category")
+	lookupSeconds = newHistogram("urllookup_lookup_duration_seconds",
+		"Time to look up a request of URLs.", latencyBuckets)
+	diskLoadSeconds = newHistogram("urllookup_disk_load_duration_seconds",
+		"Time to load URLs missed in memory from the disk, by store backend.", latencyBuckets, "backend")
+	configLoadSeconds = newHistogram("urllookup_config_load_duration_seconds",
+		"Time to read and parse a configuration file.", latencyBuckets)
+	reloadsTotal = newCounter("urllookup_reloads_total",
+		"Configuration reloads by outcome.", "outcome")
+	lastReloadTime = newGauge("urllookup_last_reload_success_timestamp_seconds",
+		"Time of the last successful configuration reload.")
+	expiredLookupsTotal = newCounter("urllookup_expired_lookups_total",
+		"Lookups that found an expired record not purged yet.")
+	purgedTotal = newCounter("urllookup_purged_records_total",
+		"Expired records purged by the sweeper or skipped when loaded.")
+	throttledTotal = newCounter("urllookup_throttled_requests_total",
+		"Requests rejected by the rate limiter by API key, or by IP for the clients without one.", "client")
+)
+
+// Escape a label value in the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format the labels of a series, with extra labels appended such as le
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// The key of a series by its label values
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// counter is a family of counters with the same labels
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	series map[string]float64
+	values map[string][]string
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]float64),
+		values: make(map[string][]string),
+	}
+}
+
+func (c *counter) add(v float64, values ...string) {
+	key := seriesKey(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = values
+	}
+	c.series[key] += v
+}
+
+func (c *counter) inc(values ...string) {
+	c.add(1, values...)
+}
+
+// Drop a series, and add its count to another one, so that the total is kept
+func (c *counter) fold(from, to []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := seriesKey(from)
+	v, ok := c.series[key]
+	if !ok {
+		return
+	}
+	delete(c.series, key)
+	delete(c.values, key)
+	key = seriesKey(to)
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = to
+	}
+	c.series[key] += v
+}
+
+func (c *counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.values[key]), formatValue(c.series[key]))
+	}
+}
+
+// gauge is a gauge without labels
+type gauge struct {
+	name  string
+	help  string
+	lock  sync.Mutex
+	value float64
+}
+
+func newGauge(name, help string) *gauge {
+	return &gauge{name: name, help: help}
+}
+
+func (g *gauge) set(v float64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.value = v
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.value))
+}
+
+// histogramSeries is the observations of a histogram with given label values
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogram is a family of histograms with the same labels and buckets
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+func (h *histogram) observe(v float64, values ...string) {
+	key := seriesKey(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	series := h.series[key]
+	if series == nil {
+		series = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += v
+}
+
+// Observe the time since start in seconds
+func (h *histogram) since(start time.Time, values ...string) {
+	h.observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %v\n", h.name,
+				formatLabels(h.labels, series.values, "le", formatValue(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %v\n", h.name, formatLabels(h.labels, series.values, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.values), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %v\n", h.name, formatLabels(h.labels, series.values), series.count)
+	}
+}
+
+// Write a metric collected when scraped
+func writeSample(w io.Writer, name, kind, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatValue(v))
+}
+
+// The verdict of a looked up URL, either safe, unsafe or unknown
+func verdictOf(info *URLInfo) string {
+	switch {
+	case info == notFound:
+		return "unknown"
+	case info.Safe:
+		return "safe"
+	}
+	return "unsafe"
+}
+
+// Count the looked up URLs by verdict and category
+func countLookups(urlinfos []*URLInfo) {
+	for _, info := range urlinfos {
+		lookupsTotal.inc(verdictOf(info), info.Category)
+	}
+}
+
+// writeMetrics writes the metrics in the Prometheus text format. The ones of the
+// store and the snapshots are collected now.
+func (s *urlLookupServer) writeMetrics(w io.Writer) {
+	lookupsTotal.write(w)
+	lookupSeconds.write(w)
+	diskLoadSeconds.write(w)
+	configLoadSeconds.write(w)
+	reloadsTotal.write(w)
+	lastReloadTime.write(w)
+	expiredLookupsTotal.write(w)
+	purgedTotal.write(w)
+	throttledTotal.write(w)
+
+	if snapshots := s.snapshots(); snapshots.Current != nil {
+		writeSample(w, "urllookup_snapshot_version", "gauge",
+			"Version of the current configuration snapshot.", float64(snapshots.Current.Version))
+		writeSample(w, "urllookup_snapshot_urls", "gauge",
+			"URLs in the current configuration snapshot.", float64(snapshots.Current.URLs))
+		writeSample(w, "urllookup_snapshot_rules", "gauge",
+			"Host and prefix rules in the current configuration snapshot.", float64(snapshots.Current.Rules))
+		writeSample(w, "urllookup_snapshot_expired_records", "gauge",
+			"Expired records in the current configuration snapshot, not purged yet.", float64(snapshots.Current.Expired))
+	}
+
+	stats := s.store.Stats()
+	writeSample(w, "urllookup_cache_urls", "gauge", "URLs cached in memory.", float64(stats.Cached))
+	if stats.Capacity > 0 {
+		writeSample(w, "urllookup_cache_capacity", "gauge", "Maximum number of URLs cached in memory.", float64(stats.Capacity))
+	}
+	writeSample(w, "urllookup_cache_hits_total", "counter", "Lookups of URLs found in memory.", float64(stats.Hits))
+	writeSample(w, "urllookup_cache_misses_total", "counter", "Lookups of URLs not found in memory.", float64(stats.Misses))
+	writeSample(w, "urllookup_cache_evictions_total", "counter", "URLs evicted from memory.", float64(stats.Evictions))
+	writeSample(w, "urllookup_cache_spills_total", "counter",
+		"Evicted URLs written to the disk.", float64(stats.Spills))
+	writeSample(w, "urllookup_cache_recoveries_total", "counter",
+		"Corrupted files rebuilt from the configuration files.", float64(stats.Recoveries))
+
+	if store, ok := s.store.(bucketedStore); ok {
+		name := "urllookup_cache_bucket_urls"
+		fmt.Fprintf(w, "# HELP %s URLs cached in memory by bucket.\n# TYPE %s gauge\n", name, name)
+		for bucketNo, cached := range store.BucketOccupancy() {
+			fmt.Fprintf(w, "%s{bucket=\"%v\"} %v\n", name, bucketNo, cached)
+		}
+	}
+}
+
+func (s *urlLookupServer) getMetrics(request *restful.Request, response *restful.Response) {
+	response.Header().Set("Content-Type", metricsContentType)
+	response.WriteHeader(http.StatusOK)
+	s.writeMetrics(response)
+}
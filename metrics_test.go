@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test the text format of the counters and histograms
+func TestMetricsFormat(t *testing.T) {
+	c := newCounter("test_total", "Test counter.", "verdict", "category")
+	c.inc("safe", "news")
+	c.add(2, "unsafe", `say "hi"\`+"\n")
+	h := newHistogram("test_seconds", "Test histogram.", []float64{.1, 1}, "backend")
+	h.observe(.05, "bolt")
+	h.observe(.5, "bolt")
+	h.observe(5, "bolt")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	h.write(&buf)
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{verdict="safe",category="news"} 1
+test_total{verdict="unsafe",category="say \"hi\"\\\n"} 2
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{backend="bolt",le="0.1"} 1
+test_seconds_bucket{backend="bolt",le="1"} 2
+test_seconds_bucket{backend="bolt",le="+Inf"} 3
+test_seconds_sum{backend="bolt"} 5.55
+test_seconds_count{backend="bolt"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected metrics:\n%v\nexpected:\n%v\n", buf.String(), expected)
+	}
+}
+
+// Test the metrics of the lookups, the cache and the reloads
+func TestMetrics(t *testing.T) {
+	store, remove := newTestBucketStore(t, 7, 2)
+	defer remove()
+	server := &urlLookupServer{store: store}
+	entries := []URLDBEntry{
+		{HostAndPort: "www.metrics.com:80", OriginalPath: "a", Category: "metrics-safe", Safe: boolPtr(true)},
+		{HostAndPort: "www.metrics.com:80", OriginalPath: "b", Category: "metrics-unsafe", Safe: boolPtr(false)},
+		{HostAndPort: "www.metrics.com:80", OriginalPath: "c", Category: "metrics-unsafe", Safe: boolPtr(false)},
+	}
+	for i := range entries {
+		if err := server.addEntry(&entries[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entries[i], err)
+		}
+	}
+	urls := []URL{{"www.metrics.com:80", "a"}, {"www.metrics.com:80", "b"}, {"www.metrics.com:80", "c"}, {"www.metrics.com:80", "c"}}
+	if _, err := server.lookup(urls); err != nil {
+		t.Errorf("Failed to look up: %v\n", err)
+	}
+
+	var buf bytes.Buffer
+	server.writeMetrics(&buf)
+	metrics := buf.String()
+	for _, line := range []string{
+		`urllookup_lookups_total{verdict="safe",category="metrics-safe"} 1`,
+		`urllookup_lookups_total{verdict="unsafe",category="metrics-unsafe"} 3`,
+		`urllookup_snapshot_version 3`,
+		`urllookup_snapshot_urls 3`,
+		`urllookup_cache_urls 2`,
+		`urllookup_cache_capacity 2`,
+		`# TYPE urllookup_lookup_duration_seconds histogram`,
+		`# TYPE urllookup_disk_load_duration_seconds histogram`,
+		`# TYPE urllookup_cache_bucket_urls gauge`,
+		`urllookup_reloads_total{outcome="success"}`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Missing metric %v in:\n%v\n", line, metrics)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// How often the buckets of the idle clients are dropped
+const pruneInterval = time.Minute
+
+// rateLimitOptions defines the rate limits of the clients
+type rateLimitOptions struct {
+	// Requests per second of a client, 0 to not limit them
+	rate float64
+	// Requests a client can make at once, the rate rounded up if 0
+	burst int
+	// JSON file of the limits of given clients, which override the ones above
+	limitsFile string
+}
+
+// ClientLimit is the rate limit of a client, which is the ID of its API key,
+// key:<first 8 hex digits of its sha256>, or its IP without API keys. A rate of
+// 0 doesn't limit it.
+type ClientLimit struct {
+	Client string  `json:"client"`
+	Rate   float64 `json:"rate"`
+	Burst  int     `json:"burst,omitempty"`
+}
+
+// RateLimits is the content of the file of the client limits
+type RateLimits struct {
+	Clients []ClientLimit `json:"clients"`
+}
+
+// The number of tokens of a full bucket
+func (l ClientLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+func (l ClientLimit) validate() error {
+	if l.Rate < 0 || math.IsNaN(l.Rate) || math.IsInf(l.Rate, 0) {
+		return fmt.Errorf("invalid rate %v", l.Rate)
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("invalid burst %v", l.Burst)
+	}
+	return nil
+}
+
+// tokenBucket holds the tokens of a client, which are refilled at its rate up
+// to its burst, and taken one per request
+type tokenBucket struct {
+	rate      float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	throttled bool
+}
+
+// Refill the bucket up to now
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// take takes a token at now, or returns how long until there is one
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits the requests of every client with a token bucket
+type rateLimiter struct {
+	defaultLimit ClientLimit
+	limits       map[string]ClientLimit
+	lock         sync.Mutex
+	buckets      map[string]*tokenBucket
+	pruned       time.Time
+}
+
+// newRateLimiter creates the rate limiter of the options, or returns nil if
+// they limit no client
+func newRateLimiter(opts *rateLimitOptions) (*rateLimiter, error) {
+	limiter := &rateLimiter{
+		defaultLimit: ClientLimit{Rate: opts.rate, Burst: opts.burst},
+		limits:       make(map[string]ClientLimit),
+		buckets:      make(map[string]*tokenBucket),
+	}
+	if err := limiter.defaultLimit.validate(); err != nil {
+		return nil, err
+	}
+	if opts.limitsFile != "" {
+		data, err := ioutil.ReadFile(opts.limitsFile)
+		if err != nil {
+			return nil, err
+		}
+		limits := &RateLimits{}
+		if err := json.Unmarshal(data, limits); err != nil {
+			return nil, fmt.Errorf("%s: %v", opts.limitsFile, err)
+		}
+		for _, limit := range limits.Clients {
+			if limit.Client == "" {
+				return nil, fmt.Errorf("%s: a limit has no client", opts.limitsFile)
+			}
+			if _, ok := limiter.limits[limit.Client]; ok {
+				return nil, fmt.Errorf("%s: duplicate client %v", opts.limitsFile, limit.Client)
+			}
+			if err := limit.validate(); err != nil {
+				return nil, fmt.Errorf("%s: client %v: %v", opts.limitsFile, limit.Client, err)
+			}
+			limiter.limits[limit.Client] = limit
+		}
+		logger.infof("Read the rate limits of %v clients from %s", len(limiter.limits), opts.limitsFile)
+	}
+	if opts.rate == 0 && len(limiter.limits) == 0 {
+		return nil, nil
+	}
+	return limiter, nil
+}
+
+// The limit of a client
+func (l *rateLimiter) limit(client string) ClientLimit {
+	if limit, ok := l.limits[client]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// allow takes a token of a client at now, or returns how long until it has
+// one
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	limit := l.limit(client)
+	if limit.Rate == 0 {
+		return true, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.prune(now)
+	bucket := l.buckets[client]
+	if bucket == nil {
+		bucket = &tokenBucket{rate: limit.Rate, burst: limit.burst(), tokens: limit.burst(), last: now}
+		l.buckets[client] = bucket
+	}
+	ok, wait := bucket.take(now)
+	if !ok && !bucket.throttled {
+		logger.warnf("Throttling client %v over %v requests per second", client, limit.Rate)
+	}
+	bucket.throttled = !ok
+	return ok, wait
+}
+
+// prune drops the buckets that are full again, as a new bucket would be the
+// same. Callers hold lock.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < pruneInterval {
+		return
+	}
+	l.pruned = now
+	for client, bucket := range l.buckets {
+		if bucket.refill(now); bucket.tokens >= bucket.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// The ID of an API key, which doesn't disclose it
+func apiKeyID(key string) string {
+	return fmt.Sprintf("key:%x", sha256.Sum256([]byte(key)))[:12]
+}
+
+const (
+	// The most IPs that have their own label in the metrics
+	maxIPLabels = 100
+	// The label of the throttled requests of the other IPs
+	otherIPs = "other"
+)
+
+// ipLabels are the IPs throttled most recently, which have their own label in
+// a counter. The IPs are unbounded, as anyone can make them up, so the least
+// recent one gives up its label for a new one, and its count goes to the
+// label of the others.
+type ipLabels struct {
+	lock    sync.Mutex
+	counter *counter
+	max     int
+	order   *list.List
+	ips     map[string]*list.Element
+}
+
+func newIPLabels(c *counter, max int) *ipLabels {
+	return &ipLabels{counter: c, max: max, order: list.New(), ips: make(map[string]*list.Element)}
+}
+
+// Count a request of an IP
+func (l *ipLabels) inc(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if e, ok := l.ips[ip]; ok {
+		l.order.MoveToFront(e)
+	} else {
+		if l.order.Len() >= l.max {
+			oldest := l.order.Remove(l.order.Back()).(string)
+			delete(l.ips, oldest)
+			l.counter.fold([]string{oldest}, []string{otherIPs})
+		}
+		l.ips[ip] = l.order.PushFront(ip)
+	}
+	l.counter.inc(ip)
+}
+
+// The IPs with their own label in the metrics of the throttled requests
+var throttledIPs = newIPLabels(throttledTotal, maxIPLabels)
+
+// countThrottled counts a throttled request of a client in the metrics. The
+// API keys are as many as their file has, while only the IPs throttled most
+// recently have their own label.
+func countThrottled(client string) {
+	if strings.HasPrefix(client, "key:") {
+		throttledTotal.inc(client)
+		return
+	}
+	throttledIPs.inc(client)
+}
+
+// clientID returns the client of a request, by its API key if they are
+// required, or by its IP otherwise
+func (s *urlLookupServer) clientID(request *restful.Request) string {
+	return s.client(request.HeaderParameter(apiKeyHeader), request.Request.RemoteAddr)
+}
+
+// client returns the client with an API key and a remote address
+func (s *urlLookupServer) client(apiKey, remoteAddr string) string {
+	if s.apiKeys != nil {
+		return apiKeyID(apiKey)
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// rateLimitFilter rejects with 429 the requests of the clients over their
+// rate limits. The probes are never limited.
+func (s *urlLookupServer) rateLimitFilter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if s.rateLimiter == nil || unauthenticatedRoutes[request.Request.URL.Path] {
+		chain.ProcessFilter(request, response)
+		return
+	}
+	client := s.clientID(request)
+	if ok, wait := s.rateLimiter.allow(client, time.Now()); !ok {
+		countThrottled(client)
+		response.AddHeader("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		// Not logged, as a flood of requests would flood the log
+		writeAPIError(request, response, http.StatusTooManyRequests, errRateLimited,
+			fmt.Errorf("client %v is over its rate limit", client))
+		return
+	}
+	chain.ProcessFilter(request, response)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the clients are limited to their bursts, and then to their rates
+func TestRateLimiter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimits")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "limits.json")
+	limits := `{"clients": [{"client": "10.0.0.1", "rate": 10, "burst": 5}, {"client": "10.0.0.2", "rate": 0}]}`
+	if err := ioutil.WriteFile(path, []byte(limits), 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", path, err)
+	}
+	limiter, err := newRateLimiter(&rateLimitOptions{rate: 1, limitsFile: path})
+	if err != nil {
+		t.Errorf("Failed to create the rate limiter: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	allowed := func(client string, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if ok, _ := limiter.allow(client, now); ok {
+				count++
+			}
+		}
+		return count
+	}
+	tests := []struct {
+		client  string
+		elapsed time.Duration
+		allowed int
+	}{
+		{"10.0.0.1", 0, 5},
+		{"10.0.0.1", 200 * time.Millisecond, 2},
+		{"10.0.0.2", 0, 100},
+		{"10.0.0.3", 0, 1},
+		{"10.0.0.3", 500 * time.Millisecond, 0},
+		{"10.0.0.3", 500 * time.Millisecond, 1},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if count := allowed(test.client, 100); count != test.allowed {
+			t.Errorf("Allowed %v requests of %v, expected %v\n", count, test.client, test.allowed)
+		}
+	}
+	if ok, wait := limiter.allow("10.0.0.3", now); ok || wait <= 0 || wait > time.Second {
+		t.Errorf("Throttled client allowed %v, retry after %v\n", ok, wait)
+	}
+
+	// The buckets of the clients that are idle long enough to be full are dropped
+	now = now.Add(pruneInterval)
+	limiter.allow("10.0.0.4", now)
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%v buckets after a prune, expected 1\n", len(limiter.buckets))
+	}
+
+	if limiter, err := newRateLimiter(&rateLimitOptions{}); limiter != nil || err != nil {
+		t.Errorf("Created a rate limiter without limits: %v, %v\n", limiter, err)
+	}
+	if _, err := newRateLimiter(&rateLimitOptions{rate: -1}); err == nil {
+		t.Errorf("Created a rate limiter with a negative rate\n")
+	}
+}
+
+// Test that the requests over the limit of their API key are rejected with 429
+func TestRateLimitFilter(t *testing.T) {
+	limiter, err := newRateLimiter(&rateLimitOptions{rate: 0.1, burst: 2})
+	if err != nil {
+		t.Errorf("Failed to create the rate limiter: %v\n", err)
+		return
+	}
+	keys := &apiKeys{digests: make(map[[32]byte]bool)}
+	for _, key := range []string{"key1", "key2"} {
+		keys.digests[sha256.Sum256([]byte(key))] = true
+	}
+	server := &urlLookupServer{store: newMemStore(), apiKeys: keys, rateLimiter: limiter}
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set(apiKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		server.newContainer().ServeHTTP(recorder, request)
+		return recorder
+	}
+	lookup := "/urlinfo/1/www.cnn.com:80/news"
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if status := get(lookup, "key1").Code; status != expected {
+			t.Errorf("Request %v of key1 returned %v, expected %v\n", i, status, expected)
+		}
+	}
+	recorder := get(lookup, "key1")
+	apiErr := &APIError{}
+	json.Unmarshal(recorder.Body.Bytes(), apiErr)
+	if retry := recorder.Header().Get("Retry-After"); recorder.Code != http.StatusTooManyRequests ||
+		apiErr.Code != errRateLimited || retry != "10" {
+		t.Errorf("Throttled request returned %v, %v, retry after %v\n", recorder.Code, *apiErr, retry)
+	}
+	if status := get(lookup, "key2").Code; status != http.StatusOK {
+		t.Errorf("Request of key2 returned %v\n", status)
+	}
+	if status := get("/healthz", "key1").Code; status == http.StatusTooManyRequests {
+		t.Errorf("Probe of key1 was throttled\n")
+	}
+
+	var metrics bytes.Buffer
+	server.writeMetrics(&metrics)
+	series := `urllookup_throttled_requests_total{client="` + apiKeyID("key1") + `"}`
+	if !strings.Contains(metrics.String(), series) {
+		t.Errorf("Metrics have no %v\n", series)
+	}
+
+	// Without API keys, the clients are limited and counted by IP
+	server.apiKeys = nil
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		for i := 0; i < 3; i++ {
+			request := httptest.NewRequest("GET", lookup, nil)
+			request.RemoteAddr = ip + ":1234"
+			server.newContainer().ServeHTTP(httptest.NewRecorder(), request)
+		}
+	}
+	metrics.Reset()
+	server.writeMetrics(&metrics)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if series := `urllookup_throttled_requests_total{client="` + ip + `"}`; !strings.Contains(metrics.String(), series) {
+			t.Errorf("Metrics have no %v:\n%v\n", series, metrics.String())
+		}
+	}
+}
+
+// Test that only the IPs throttled most recently have their own label, and
+// that the others are counted together
+func TestIPLabels(t *testing.T) {
+	c := newCounter("test_throttled_total", "Test counter.", "client")
+	labels := newIPLabels(c, 2)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3", "10.0.0.2", "10.0.0.2"} {
+		labels.inc(ip)
+	}
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	expected := `# HELP test_throttled_total Test counter.
+# TYPE test_throttled_total counter
+test_throttled_total{client="10.0.0.2"} 2
+test_throttled_total{client="10.0.0.3"} 1
+test_throttled_total{client="other"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected metrics:\n%v\nexpected:\n%v\n", buf.String(), expected)
+	}
+}
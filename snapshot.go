@@ -0,0 +1,505 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// The pseudo configuration file of the url records added one by one
+const addedRecords = "(added)"
+
+// snapshot is a validated version of the url records of all the configuration
+// files, with the host rules built from them. It's not modified once built, so
+// that it can be swapped in at once, and swapped back by a rollback.
+type snapshot struct {
+	version int
+	created time.Time
+	files   map[string]*configFile
+	rules   *hostRules
+	// urls and ruleCount are how many URLs and rules the files merge into
+	urls      int
+	ruleCount int
+	// added and deleted are the records added and deleted with the admin API
+	// that the snapshot is built with, which a rollback restores
+	added   map[entryKey]URLDBEntry
+	deleted map[entryKey]bool
+}
+
+// SnapshotInfo describes a snapshot
+type SnapshotInfo struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Files are the digests of the configuration files by path
+	Files map[string]string `json:"files"`
+	URLs  int               `json:"urls"`
+	Rules int               `json:"rules"`
+	// Expired are the records that have expired, and are not purged yet
+	Expired int `json:"expired"`
+}
+
+// SnapshotsInfo describes the current snapshot, and the previous one a rollback
+// goes back to
+type SnapshotsInfo struct {
+	Current  *SnapshotInfo `json:"current"`
+	Previous *SnapshotInfo `json:"previous,omitempty"`
+}
+
+// The paths of configuration files, with the records added with the admin API
+// first, as they override the others, and then in order
+func sortedPaths(files map[string]*configFile) []string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		if path != addedRecords {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	if files[addedRecords] != nil {
+		paths = append([]string{addedRecords}, paths...)
+	}
+	return paths
+}
+
+// mergeFiles merges the digests of the verdicts of the URLs of configuration
+// files, and their rules. A URL may be in several files with the same verdict,
+// but not with conflicting ones, except for the records added with the admin
+// API, which override the others.
+func mergeFiles(files map[string]*configFile) (map[URL]uint64, fileEntries, error) {
+	paths := sortedPaths(files)
+	verdicts := make(map[URL]uint64)
+	rules := make(fileEntries)
+	conflict := func(key entryKey, path string) error {
+		for _, origin := range paths {
+			if origin != addedRecords && files[origin].has(key) {
+				return fmt.Errorf("conflicting verdicts for %v in %s and %s", key, origin, path)
+			}
+		}
+		return fmt.Errorf("conflicting verdicts for %v in %s", key, path)
+	}
+	for _, path := range paths {
+		if path == addedRecords {
+			continue
+		}
+		file := files[path]
+		for url, digest := range file.urls {
+			if existing, ok := verdicts[url]; ok && existing != digest {
+				return nil, nil, conflict(entryKey{url: url}, path)
+			}
+			verdicts[url] = digest
+		}
+		for key, info := range file.rules {
+			if existing, ok := rules[key]; ok && !existing.equal(info) {
+				return nil, nil, conflict(key, path)
+			}
+			rules[key] = info
+		}
+	}
+	if added := files[addedRecords]; added != nil {
+		for key, info := range added.pinned {
+			switch {
+			case info == nil && key.stored():
+				delete(verdicts, key.url)
+			case info == nil:
+				delete(rules, key)
+			case key.stored():
+				verdicts[key.url] = added.urls[key.url]
+			default:
+				rules[key] = info
+			}
+		}
+	}
+	return verdicts, rules, nil
+}
+
+// newSnapshot merges configuration files, and builds the host rules from their
+// rules
+func newSnapshot(version int, files map[string]*configFile) (*snapshot, error) {
+	verdicts, entries, err := mergeFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	rules := &hostRules{}
+	for key, info := range entries {
+		if key.prefix {
+			rules.addPrefix(key.url.hostAndPort, key.url.originalPath, info)
+		} else {
+			rules.add(key.url.hostAndPort, info)
+		}
+	}
+	return &snapshot{
+		version:   version,
+		created:   time.Now(),
+		files:     files,
+		rules:     rules,
+		urls:      len(verdicts),
+		ruleCount: len(entries),
+	}, nil
+}
+
+// The digests of the verdicts of the URLs of the snapshot
+func (snap *snapshot) verdicts() map[URL]uint64 {
+	verdicts, _, _ := mergeFiles(snap.files)
+	return verdicts
+}
+
+func (snap *snapshot) info() *SnapshotInfo {
+	if snap == nil {
+		return nil
+	}
+	info := &SnapshotInfo{
+		Version: snap.version,
+		Created: snap.created,
+		Files:   make(map[string]string),
+		URLs:    snap.urls,
+		Rules:   snap.ruleCount,
+	}
+	now := expiryNow()
+	for path, file := range snap.files {
+		info.Files[path] = file.digest
+		info.Expired += file.expired(now)
+	}
+	return info
+}
+
+// A copy of the configuration files of the current snapshot, from which the
+// next one is built
+func (s *urlLookupServer) currentFiles() map[string]*configFile {
+	files := make(map[string]*configFile)
+	if s.current != nil {
+		for path, file := range s.current.files {
+			files[path] = file
+		}
+	}
+	return files
+}
+
+// stashFiles stashes the configuration files just read, and returns their
+// records, which are dropped from the files
+func (s *urlLookupServer) stashFiles(files map[string]*configFile) (map[string]fileEntries, error) {
+	if s.stash == nil {
+		s.stash = newConfigStash(s.urlCachePath)
+	}
+	fresh := make(map[string]fileEntries)
+	for path, file := range files {
+		if file.data == nil {
+			continue
+		}
+		if err := s.stash.put(file.digest, file.data); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		fresh[path] = file.parsed
+		file.data, file.parsed = nil, nil
+	}
+	return fresh, nil
+}
+
+// reload builds a snapshot of configuration files and of the records added with
+// the admin API, and swaps it in. The current snapshot is kept if the files are
+// not valid. Callers hold reloadLock.
+func (s *urlLookupServer) reload(files map[string]*configFile) error {
+	delete(files, addedRecords)
+	if len(s.added) > 0 || len(s.deleted) > 0 {
+		files[addedRecords] = s.addedFile()
+	}
+	// The version is taken only by a snapshot swapped in
+	next, err := newSnapshot(s.version+1, files)
+	if err != nil {
+		logger.warnf("Rejected snapshot %v: %v", s.version+1, err)
+		reloadsTotal.inc(reloadRejected)
+		return err
+	}
+	next.added, next.deleted = copyAdmin(s.added, s.deleted)
+	fresh, err := s.stashFiles(files)
+	if err == nil {
+		err = s.swap(next, s.current, fresh)
+	}
+	if err != nil {
+		logger.errorf("Failed to swap in snapshot %v: %v", next.version, err)
+		reloadsTotal.inc(reloadFailed)
+		return err
+	}
+	s.version = next.version
+	s.pruneDeleted()
+	reloadsTotal.inc(reloadSucceeded)
+	lastReloadTime.set(float64(next.created.Unix()))
+	return nil
+}
+
+// The URLs the store has before the first snapshot is swapped in, with the
+// digests of their verdicts. A persistent store has the URLs of the files
+// imported before a restart, which are taken as up to date if the files
+// haven't changed since, and as unknown otherwise.
+func (s *urlLookupServer) storedVerdicts(next *snapshot) map[URL]uint64 {
+	stored := make(map[URL]uint64)
+	store, ok := s.store.(persistentStore)
+	if !ok {
+		return stored
+	}
+	for _, path := range store.ImportedFiles() {
+		digest, urls := store.Imported(path)
+		file := next.files[path]
+		for _, url := range urls {
+			if file != nil && file.digest == digest && file.urls[url] != 0 {
+				stored[url] = file.urls[url]
+			} else if _, ok := stored[url]; !ok {
+				stored[url] = 0
+			}
+		}
+	}
+	return stored
+}
+
+// storeDelta is how the store changes from one snapshot to another
+type storeDelta struct {
+	changed URLDB
+	removed map[URL]bool
+}
+
+// newStoreDelta compares the URLs of one snapshot to those of another by the
+// digests of their verdicts, and reads the records of the changed ones from the
+// files of source, which they come from. fresh are the records of the files
+// just read. The changed URLs are not restored without a source.
+func (s *urlLookupServer) newStoreDelta(from, to map[URL]uint64, source *snapshot,
+	fresh map[string]fileEntries) (*storeDelta, error) {
+	delta := &storeDelta{changed: make(URLDB), removed: make(map[URL]bool)}
+	for url := range from {
+		if _, ok := to[url]; !ok {
+			delta.removed[url] = true
+		}
+	}
+	changed := make(map[URL]bool)
+	for url, digest := range to {
+		if from[url] != digest {
+			changed[url] = true
+		}
+	}
+	if source == nil {
+		return delta, nil
+	}
+
+	now := expiryNow()
+	for _, path := range sortedPaths(source.files) {
+		if len(changed) == 0 {
+			break
+		}
+		file := source.files[path]
+		var entries fileEntries
+		for url := range changed {
+			if _, ok := file.urls[url]; !ok {
+				continue
+			}
+			if entries == nil {
+				var err error
+				if entries = fresh[path]; entries == nil {
+					if entries, err = file.entries(s.stash, s.taxonomy, now); err != nil {
+						return nil, fmt.Errorf("%s: %v", path, err)
+					}
+				}
+			}
+			if info := entries[entryKey{url: url}]; info != nil {
+				delta.changed[url] = info
+			} else {
+				// It has expired since
+				delta.removed[url] = true
+			}
+			delete(changed, url)
+		}
+	}
+	return delta, nil
+}
+
+// Apply a delta to the store
+func (s *urlLookupServer) updateStore(delta *storeDelta) error {
+	logger.infof("%v urls changed and %v removed", len(delta.changed), len(delta.removed))
+	for url := range delta.removed {
+		if err := s.store.Delete(url); err != nil {
+			return err
+		}
+	}
+	if store, ok := s.store.(bulkStore); ok {
+		return store.PutAll(delta.changed)
+	}
+	for url, info := range delta.changed {
+		if err := s.store.Put(url, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceOf returns the source of the URLs of a snapshot, from which a store
+// rebuilds its corrupted files. It reads the files of the snapshot again.
+func (s *urlLookupServer) sourceOf(snap *snapshot) urlSource {
+	stash, tax := s.stash, s.taxonomy
+	return func(keep func(url URL) bool) (URLDB, error) {
+		urldb := make(URLDB)
+		now := expiryNow()
+		for _, path := range sortedPaths(snap.files) {
+			entries, err := snap.files[path].entries(stash, tax, now)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			for key, info := range entries {
+				if _, ok := urldb[key.url]; !ok && key.stored() && keep(key.url) {
+					urldb[key.url] = info
+				}
+			}
+		}
+		for url, info := range urldb {
+			if info == nil {
+				// Deleted with the admin API
+				delete(urldb, url)
+			}
+		}
+		return urldb, nil
+	}
+}
+
+// switchTo swaps a snapshot in, with the delta that the store may not have yet
+func (s *urlLookupServer) switchTo(current, previous *snapshot, pending *storeDelta) {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	s.current, s.previous, s.pending = current, previous, pending
+}
+
+// swap swaps the next snapshot in, keeping previous for a rollback, and updates
+// the store with the URLs that differ from the current snapshot. The changed
+// records are read, and the store is updated, without holding up the lookups:
+// they see the delta before the store has it, so that they see either snapshot
+// in full. If the store fails to be updated, the current snapshot is swapped
+// back in, and the store restored to it. fresh are the records of the files
+// just read.
+func (s *urlLookupServer) swap(next, previous *snapshot, fresh map[string]fileEntries) error {
+	var current map[URL]uint64
+	if s.current != nil {
+		current = s.current.verdicts()
+	} else {
+		current = s.storedVerdicts(next)
+	}
+	target := next.verdicts()
+	delta, err := s.newStoreDelta(current, target, next, fresh)
+	if err != nil {
+		return err
+	}
+
+	oldCurrent, oldPrevious := s.current, s.previous
+	store, recoverable := s.store.(recoverableStore)
+	if recoverable {
+		store.SetSource(s.sourceOf(next))
+	}
+	s.switchTo(next, previous, delta)
+	if err := s.updateStore(delta); err != nil {
+		undo, undoErr := s.newStoreDelta(target, current, oldCurrent, nil)
+		if recoverable && oldCurrent != nil {
+			store.SetSource(s.sourceOf(oldCurrent))
+		}
+		s.switchTo(oldCurrent, oldPrevious, undo)
+		if undoErr == nil {
+			undoErr = s.updateStore(undo)
+		}
+		if undoErr != nil {
+			logger.errorf("Failed to restore snapshot: %v", undoErr)
+		}
+		s.switchTo(oldCurrent, oldPrevious, nil)
+		return err
+	}
+	s.switchTo(next, previous, nil)
+	logger.infof("Swapped in snapshot %v of %v files", next.version, len(next.files))
+
+	// The snapshot stays in even if the imports fail to be recorded, and they
+	// are recorded again with the next one
+	if store, ok := s.store.(persistentStore); ok {
+		for path, file := range next.files {
+			if digest, _ := store.Imported(path); digest != file.digest {
+				urls := make([]URL, 0, len(file.urls))
+				for url := range file.urls {
+					urls = append(urls, url)
+				}
+				if err := store.SetImported(path, file.digest, urls); err != nil {
+					logger.errorf("Failed to record the import of %s: %v", path, err)
+				}
+			}
+		}
+		for _, path := range store.ImportedFiles() {
+			if next.files[path] == nil {
+				if err := store.ForgetImported(path); err != nil {
+					logger.errorf("Failed to forget the import of %s: %v", path, err)
+				}
+			}
+		}
+	}
+	s.keepStashed()
+	return nil
+}
+
+// Drop the stashed versions of the configuration files that neither the
+// current snapshot nor the previous one have
+func (s *urlLookupServer) keepStashed() {
+	digests := make(map[string]bool)
+	for _, snap := range []*snapshot{s.current, s.previous} {
+		if snap != nil {
+			for _, file := range snap.files {
+				digests[file.digest] = true
+			}
+		}
+	}
+	s.stash.keep(digests)
+}
+
+// rollback swaps the previous snapshot back in, with the records added and
+// deleted with the admin API that it was built with, which replace those of the
+// journal. Rolling back again undoes it.
+func (s *urlLookupServer) rollback() error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	previous, current := s.previous, s.current
+	if previous == nil {
+		return fmt.Errorf("no previous snapshot")
+	}
+	if err := s.swap(previous, current, nil); err != nil {
+		logger.errorf("Failed to roll back to snapshot %v: %v", previous.version, err)
+		return err
+	}
+	added, deleted := s.added, s.deleted
+	s.added, s.deleted = copyAdmin(previous.added, previous.deleted)
+	s.pruneDeleted()
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.compact(s.addedRecords(), s.deletedRecords(), true); err != nil {
+		logger.errorf("Failed to journal the rollback to snapshot %v: %v", previous.version, err)
+		s.added, s.deleted = added, deleted
+		if err := s.swap(current, previous, nil); err != nil {
+			logger.errorf("Failed to restore snapshot %v: %v", current.version, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *urlLookupServer) snapshots() *SnapshotsInfo {
+	s.snapshotLock.RLock()
+	defer s.snapshotLock.RUnlock()
+	return &SnapshotsInfo{Current: s.current.info(), Previous: s.previous.info()}
+}
+
+func (s *urlLookupServer) getSnapshots(request *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(s.snapshots()); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func (s *urlLookupServer) rollbackSnapshot(request *restful.Request, response *restful.Response) {
+	defer func() { s.logAudit(request, auditRollback, nil, response.StatusCode()) }()
+	if err := s.rollback(); err != nil {
+		writeError(request, response, http.StatusConflict, errNoSnapshot, err)
+		return
+	}
+	if err := response.WriteEntity(s.snapshots()); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
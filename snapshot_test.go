@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that invalid configuration files are rejected as a whole, and keep the
+// current snapshot
+func TestRejectSnapshot(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)}
+	food := URLDBEntry{HostAndPort: "www.food.com:80", OriginalPath: "recipes", Category: "food", Safe: boolPtr(true)}
+	cfg1, cfg2 := filepath.Join(urlCfgPath, "urlcfg1.json"), filepath.Join(urlCfgPath, "urlcfg2.json")
+	writeURLs(t, cfg1, news, news)
+	writeURLs(t, cfg2, food, news)
+
+	store, remove := newTestBucketStore(t, 31, 100)
+	defer remove()
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+		return
+	}
+	urls := []URL{{"www.cnn.com:80", "news"}, {"www.food.com:80", "recipes"}}
+
+	invalid := []struct {
+		name string
+		data string
+	}{
+		{"unknown field", `{"urls":[{"host":"www.food.com:80","path":"recipes","category":"junk","safe":true,"color":"red"}]}`},
+		{"missing category", `{"urls":[{"host":"www.food.com:80","path":"recipes","safe":true}]}`},
+		{"missing path", `{"urls":[{"host":"www.food.com:80","category":"junk","safe":true}]}`},
+		{"wrong type", `{"urls":[{"host":"www.food.com:80","path":"recipes","category":"junk","safe":"yes"}]}`},
+		{"trailing data", `{"urls":[{"host":"www.food.com:80","path":"recipes","category":"junk","safe":true}]}}`},
+		{"half written", `{"urls":[{"host":"www.food.com:80","path":"recipes","category":"junk","safe":true},{"ho`},
+		{"conflicting verdicts", `{"urls":[{"host":"www.food.com:80","path":"recipes","category":"junk","safe":true},
+			{"host":"www.food.com:80","path":"recipes","category":"food","safe":true}]}`},
+		{"conflicting files", `{"urls":[{"host":"www.cnn.com:80","path":"news","category":"fake-news","safe":true}]}`},
+	}
+	for _, test := range invalid {
+		if err := ioutil.WriteFile(cfg2, []byte(test.data), 0666); err != nil {
+			t.Errorf("Failed to write to file %v: %v\n", cfg2, err)
+		}
+		if err := server.loadURLs(); err == nil {
+			t.Errorf("Loaded a file with %v\n", test.name)
+		}
+		if err := server.loadFromFile(cfg2); err == nil {
+			t.Errorf("Reloaded a file with %v\n", test.name)
+		}
+		if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, []string{"news", "food"}) {
+			t.Errorf("Unmatched records after rejecting a file with %v: %v\n", test.name, categories)
+		}
+	}
+	if snapshots := server.snapshots(); snapshots.Current.Version != 1 || snapshots.Previous != nil {
+		t.Errorf("Unexpected snapshots after rejections: %v, %v\n", snapshots.Current, snapshots.Previous)
+	}
+
+	// The rejected snapshots don't take a version
+	writeURLs(t, cfg2, food)
+	if err := server.loadFromFile(cfg2); err != nil {
+		t.Errorf("Failed to reload %v: %v\n", cfg2, err)
+	}
+	if version := server.snapshots().Current.Version; version != 2 {
+		t.Errorf("Current snapshot %v after rejections, expected 2\n", version)
+	}
+}
+
+// Test rolling back to the previous snapshot, and undoing the rollback
+func TestRollbackSnapshot(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)}
+	evil := URLDBEntry{HostAndPort: "*.evil.com:80", OriginalPath: "*", Category: "malware"}
+	cfg := filepath.Join(urlCfgPath, "urlcfg1.json")
+	writeURLs(t, cfg, news, evil)
+
+	store, remove := newTestBucketStore(t, 31, 100)
+	defer remove()
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: store}
+	if err := server.rollback(); err == nil {
+		t.Errorf("Rolled back without a snapshot\n")
+	}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+	if err := server.rollback(); err == nil {
+		t.Errorf("Rolled back without a previous snapshot\n")
+	}
+
+	news.Category = "fake-news"
+	sports := URLDBEntry{HostAndPort: "www.espn.com:80", OriginalPath: "programming", Category: "sports", Safe: boolPtr(true)}
+	writeURLs(t, cfg, news, sports)
+	if err := server.loadFromFile(cfg); err != nil {
+		t.Errorf("Failed to load %v: %v\n", cfg, err)
+	}
+
+	urls := []URL{{"www.cnn.com:80", "news"}, {"a.evil.com:80", "x"}, {"www.espn.com:80", "programming"}}
+	versions := [][]string{{"news", "malware", "Unknown"}, {"fake-news", "Unknown", "sports"}}
+	for i, expected := range []int{1, 0, 1} {
+		if i > 0 {
+			if err := server.rollback(); err != nil {
+				t.Errorf("Failed to roll back: %v\n", err)
+			}
+		}
+		if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, versions[expected]) {
+			t.Errorf("Unmatched records after %v rollbacks: %v, expected %v\n", i, categories, versions[expected])
+		}
+		if version := server.snapshots().Current.Version; version != expected+1 {
+			t.Errorf("Current snapshot %v after %v rollbacks, expected %v\n", version, i, expected+1)
+		}
+	}
+}
+
+// Test that lookups see either snapshot in full while they are swapped
+func TestSwapSnapshot(t *testing.T) {
+	store, remove := newTestBucketStore(t, 7, 10)
+	defer remove()
+	server := &urlLookupServer{store: store}
+	urls := []URL{}
+	for _, host := range []string{"www.cnn.com:80", "www.espn.com:80", "www.food.com:80", "www.fun.com:80"} {
+		urls = append(urls, URL{host, "index.html"}, URL{host, "news"})
+	}
+	versions := []map[string]*configFile{}
+	for _, category := range []string{"old", "new"} {
+		entries := make(fileEntries)
+		for _, url := range urls {
+			entries[entryKey{url: url}] = &URLInfo{Category: category}
+		}
+		versions = append(versions, map[string]*configFile{"urlcfg.json": newPinnedFile(category, entries)})
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			categories := lookupCategories(server, urls...)
+			for _, category := range categories {
+				if category != categories[0] {
+					t.Errorf("Looked up a mix of snapshots: %v\n", categories)
+					return
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		server.reloadLock.Lock()
+		if err := server.reload(versions[i%2]); err != nil {
+			t.Errorf("Failed to reload: %v\n", err)
+		}
+		server.reloadLock.Unlock()
+	}
+	close(done)
+	wg.Wait()
+}
+
+// gatedStore is a persistent store whose puts wait for gate, if set, and fail
+// with err, and whose imports fail with importErr
+type gatedStore struct {
+	Store
+	gate      chan struct{}
+	err       error
+	importErr error
+}
+
+func (s *gatedStore) Put(url URL, info *URLInfo) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return s.Store.Put(url, info)
+}
+
+func (s *gatedStore) Imported(path string) (string, []URL) {
+	return "", nil
+}
+
+func (s *gatedStore) SetImported(path, digest string, urls []URL) error {
+	return s.importErr
+}
+
+func (s *gatedStore) ForgetImported(path string) error {
+	return s.importErr
+}
+
+func (s *gatedStore) ImportedFiles() []string {
+	return nil
+}
+
+// Test that lookups see the next snapshot while the store is being updated,
+// and the current one again if the update fails
+func TestSwapWhileUpdating(t *testing.T) {
+	store := &gatedStore{Store: newMemStore()}
+	server := &urlLookupServer{store: store}
+	news := URL{"www.cnn.com:80", "news"}
+	reload := func(category string) error {
+		entries := fileEntries{entryKey{url: news}: &URLInfo{Category: category}}
+		server.reloadLock.Lock()
+		defer server.reloadLock.Unlock()
+		return server.reload(map[string]*configFile{"urlcfg.json": newPinnedFile(category, entries)})
+	}
+	if err := reload("old"); err != nil {
+		t.Errorf("Failed to reload: %v\n", err)
+	}
+
+	store.gate = make(chan struct{})
+	done := make(chan error)
+	go func() { done <- reload("new") }()
+	deadline := time.Now().Add(5 * time.Second)
+	for lookupCategories(server, news)[0] != "new" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if category := lookupCategories(server, news)[0]; category != "new" {
+		t.Errorf("Looked up %v while the store is updated, expected new\n", category)
+	}
+	close(store.gate)
+	if err := <-done; err != nil {
+		t.Errorf("Failed to reload: %v\n", err)
+	}
+
+	store.gate, store.err = nil, fmt.Errorf("disk full")
+	if err := reload("newer"); err == nil {
+		t.Errorf("Reloaded with a failing store\n")
+	}
+	if category := lookupCategories(server, news)[0]; category != "new" || server.snapshots().Current.Version != 2 {
+		t.Errorf("Looked up %v in snapshot %v after a failed update\n", category, server.snapshots().Current.Version)
+	}
+
+	// A failure to record the imports keeps the snapshot in
+	store.err, store.importErr = nil, fmt.Errorf("disk full")
+	if err := reload("newest"); err != nil {
+		t.Errorf("Failed to reload: %v\n", err)
+	}
+	// The failed update doesn't take a version
+	if category := lookupCategories(server, news)[0]; category != "newest" || server.snapshots().Current.Version != 3 {
+		t.Errorf("Looked up %v in snapshot %v after a failed import, expected newest in 3\n",
+			category, server.snapshots().Current.Version)
+	}
+}
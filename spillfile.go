@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// The first line of a bucket file is its header: the magic, the version
+	// of the format and the checksum of the rest of the file
+	bucketFileMagic   = "url-lookup-bucket"
+	bucketFileVersion = 1
+)
+
+// corruptBucketError tells that a bucket file is corrupted
+type corruptBucketError struct {
+	fileName string
+	err      error
+}
+
+func (e *corruptBucketError) Error() string {
+	return fmt.Sprintf("corrupted bucket file %v: %v", e.fileName, e.err)
+}
+
+func bucketFileHeader(body []byte) []byte {
+	return []byte(fmt.Sprintf("%s %d sha256:%x\n", bucketFileMagic, bucketFileVersion, sha256.Sum256(body)))
+}
+
+// Check the header of a bucket file against its body, and return the body
+func checkBucketFile(data []byte) ([]byte, error) {
+	newline := bytes.IndexByte(data, '\n')
+	if newline < 0 {
+		return nil, fmt.Errorf("no header")
+	}
+	var magic, checksum string
+	var version int
+	if _, err := fmt.Sscanf(string(data[:newline]), "%s %d sha256:%s", &magic, &version, &checksum); err != nil || magic != bucketFileMagic {
+		return nil, fmt.Errorf("invalid header")
+	}
+	if version != bucketFileVersion {
+		return nil, fmt.Errorf("unsupported version %v", version)
+	}
+	body := data[newline+1:]
+	if sum := fmt.Sprintf("%x", sha256.Sum256(body)); sum != checksum {
+		return nil, fmt.Errorf("checksum %v, expected %v", sum, checksum)
+	}
+	return body, nil
+}
+
+// Write a file atomically: the data is written to a temporary file in the same
+// directory, synced to the disk, and renamed to the file, so that a crash
+// leaves either the old file or the new one
+func writeFileAtomic(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0666); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		return err
+	}
+
+	// Sync the directory so that the rename survives a crash
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
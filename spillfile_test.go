@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that a bucket file is written with a header, and that a corrupted one is
+// detected
+func TestBucketFileChecksum(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	fileName := filepath.Join(urlCachePath, "bucket0.json")
+	url := URL{"www.cnn.com:80", "news"}
+	if err := writeBucketFile(fileName, URLDB{url: &URLInfo{Category: "news", Safe: true}}); err != nil {
+		t.Errorf("Failed to write %v: %v\n", fileName, err)
+		return
+	}
+	if files, _ := filepath.Glob(filepath.Join(urlCachePath, "*")); len(files) != 1 {
+		t.Errorf("Temporary files left: %v\n", files)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Errorf("Failed to read %v: %v\n", fileName, err)
+		return
+	}
+	if !strings.HasPrefix(string(data), "url-lookup-bucket 1 sha256:") {
+		t.Errorf("Unexpected header in %q\n", data)
+	}
+	urldb := make(URLDB)
+	if err := readBucketFile(fileName, urldb); err != nil || urldb[url] == nil || urldb[url].Category != "news" {
+		t.Errorf("Failed to read %v: %v, %v\n", fileName, err, urldb)
+	}
+
+	corruptions := map[string][]byte{
+		"flipped byte":    bytes.Replace(data, []byte("news"), []byte("nevs"), 1),
+		"truncated":       data[:len(data)-5],
+		"no header":       data[bytes.IndexByte(data, '\n')+1:],
+		"unknown version": bytes.Replace(data, []byte("bucket 1"), []byte("bucket 9"), 1),
+		"empty":           {},
+	}
+	for name, corrupted := range corruptions {
+		if err := ioutil.WriteFile(fileName, corrupted, 0666); err != nil {
+			t.Errorf("Failed to write %v: %v\n", fileName, err)
+		}
+		err := readBucketFile(fileName, make(URLDB))
+		if _, ok := err.(*corruptBucketError); !ok {
+			t.Errorf("Read a %v bucket file: %v\n", name, err)
+		}
+	}
+}
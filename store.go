@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Store stores the URLs and their information
+type Store interface {
+	// Get returns the information of the URLs in the same order, nil for the
+	// URLs that are not stored
+	Get(urls []URL) ([]*URLInfo, error)
+	// Put adds or replaces a URL
+	Put(url URL, info *URLInfo) error
+	// Delete removes a URL
+	Delete(url URL) error
+	// Iterate calls fn with every stored URL until fn returns an error
+	Iterate(fn func(url URL, info *URLInfo) error) error
+	// Stats returns the statistics of the store
+	Stats() *StoreStats
+}
+
+// resizableStore is a Store whose geometry can be changed at runtime
+type resizableStore interface {
+	Store
+	Resize(buckets, capacity int) error
+}
+
+// bulkStore is a Store that adds many URLs at once more efficiently
+type bulkStore interface {
+	Store
+	PutAll(urldb URLDB) error
+}
+
+// diskStore is a Store that caches in memory the URLs it keeps on the disk
+type diskStore interface {
+	Store
+	// GetLoaded is Get that also tells which URLs were missed in memory and
+	// loaded from the disk
+	GetLoaded(urls []URL) ([]*URLInfo, []bool, error)
+}
+
+// bucketedStore is a Store that hashes the URLs into buckets
+type bucketedStore interface {
+	Store
+	// BucketOccupancy returns the number of URLs cached in each bucket
+	BucketOccupancy() []int
+}
+
+// urlSource reads the URLs of the configuration files that keep accepts
+type urlSource func(keep func(url URL) bool) (URLDB, error)
+
+// recoverableStore is a Store that rebuilds its corrupted files from the URLs of
+// the configuration files
+type recoverableStore interface {
+	Store
+	SetSource(source urlSource)
+}
+
+// persistentStore is a Store that keeps the URLs across restarts. It records
+// the digests of the configuration files imported into it, so that they are
+// not imported again, and the URLs imported from each file, so that the URLs
+// of a file changed or removed while the server was down can be retracted.
+type persistentStore interface {
+	Store
+	Imported(path string) (string, []URL)
+	SetImported(path, digest string, urls []URL) error
+	ForgetImported(path string) error
+	ImportedFiles() []string
+}
+
+// StoreStats defines the statistics of a store
+type StoreStats struct {
+	Backend string `json:"backend"`
+	// Cached is the number of URLs in memory
+	Cached   int `json:"cached"`
+	Capacity int `json:"capacity,omitempty"`
+	Buckets  int `json:"buckets,omitempty"`
+	// Hits and misses of the lookups in memory, and URLs evicted from memory
+	Hits      int64 `json:"hits,omitempty"`
+	Misses    int64 `json:"misses,omitempty"`
+	Evictions int64 `json:"evictions,omitempty"`
+	// Spills are the evicted URLs written to the disk
+	Spills int64 `json:"spills,omitempty"`
+	// Recoveries are the corrupted files rebuilt from the configuration files
+	Recoveries int64 `json:"recoveries,omitempty"`
+}
+
+// storeOptions defines the backend of the store and its settings
+type storeOptions struct {
+	backend string
+	// Number of buckets in the URL cache hash table
+	hashTableSize int
+	// Number of URLs kept in memory before buckets are vacated to files
+	maxUrlsCached int
+}
+
+func newStore(opts *storeOptions, urlCachePath string) (Store, error) {
+	switch opts.backend {
+	case bucketBackend:
+		if opts.hashTableSize <= 0 || opts.maxUrlsCached <= 0 {
+			return nil, fmt.Errorf("invalid cache geometry: %v buckets, %v urls",
+				opts.hashTableSize, opts.maxUrlsCached)
+		}
+		return newBucketStore(urlCachePath, opts.hashTableSize, opts.maxUrlsCached), nil
+	case memoryBackend:
+		return newMemStore(), nil
+	case boltBackend:
+		if opts.maxUrlsCached <= 0 {
+			return nil, fmt.Errorf("invalid cache capacity: %v urls", opts.maxUrlsCached)
+		}
+		return newBoltStore(urlCachePath, opts.maxUrlsCached)
+	default:
+		return nil, fmt.Errorf("unknown store backend '%v'", opts.backend)
+	}
+}
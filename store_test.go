@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Test the store operations on every backend
+func TestStores(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	stores := []*storeOptions{
+		{backend: bucketBackend, hashTableSize: 3, maxUrlsCached: 2},
+		{backend: memoryBackend},
+		{backend: boltBackend, maxUrlsCached: 2},
+	}
+	all := append(append([]URLDBEntry{}, entries1.URLEntries...), entries2.URLEntries...)
+	for _, opts := range stores {
+		store, err := newStore(opts, urlCachePath)
+		if err != nil {
+			t.Errorf("Failed to create %v store: %v\n", opts.backend, err)
+			continue
+		}
+		if closer, ok := store.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		urls := make([]URL, len(all))
+		for i, entry := range all {
+			urls[i] = URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath}
+			if err := store.Put(urls[i], &URLInfo{Category: entry.Category, Safe: *entry.Safe}); err != nil {
+				t.Errorf("Failed to put %v in %v store: %v\n", urls[i], opts.backend, err)
+			}
+		}
+
+		// Delete the first URL
+		if err := store.Delete(urls[0]); err != nil {
+			t.Errorf("Failed to delete %v from %v store: %v\n", urls[0], opts.backend, err)
+		}
+		infos, err := store.Get(urls)
+		if err != nil {
+			t.Errorf("Failed to get from %v store: %v\n", opts.backend, err)
+			continue
+		}
+		if infos[0] != nil {
+			t.Errorf("Deleted url %v is still in %v store\n", urls[0], opts.backend)
+		}
+		for i := 1; i < len(all); i++ {
+			if infos[i] == nil || infos[i].Category != all[i].Category || infos[i].Safe != *all[i].Safe {
+				t.Errorf("Unmatched record %v in %v store\n", all[i], opts.backend)
+			}
+		}
+
+		count := 0
+		if err := store.Iterate(func(url URL, info *URLInfo) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Errorf("Failed to iterate %v store: %v\n", opts.backend, err)
+		}
+		if count != len(all)-1 {
+			t.Errorf("Iterated %v urls in %v store, expected %v\n", count, opts.backend, len(all)-1)
+		}
+		if stats := store.Stats(); stats.Backend != opts.backend {
+			t.Errorf("Unexpected %v store stats %v\n", opts.backend, *stats)
+		}
+	}
+
+	if _, err := newStore(&storeOptions{backend: "etcd"}, urlCachePath); err == nil {
+		t.Errorf("Creating an unknown store should fail\n")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// sweep purges the records expired at now from the current snapshot: it swaps
+// in a snapshot of the configuration files without them, which deletes them
+// from the store and its files. The previous snapshot is kept for a rollback.
+// It returns how many records were purged.
+func (s *urlLookupServer) sweep(now string) (int, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	if s.current == nil || s.draining {
+		return 0, nil
+	}
+
+	purged := 0
+	files := s.currentFiles()
+	for path, file := range files {
+		expired := file.expired(now)
+		if expired == 0 {
+			continue
+		}
+		entries, err := file.entries(s.stash, s.taxonomy, now)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+		// The digest stays, as the file hasn't changed
+		swept := newConfigFile(file.digest, entries)
+		if file.pinned != nil {
+			swept.pinned = entries
+		}
+		swept.swept = now
+		files[path] = swept
+		if path == addedRecords {
+			for key, info := range file.pinned {
+				if info != nil && info.expired(now) {
+					delete(s.added, key)
+				}
+			}
+		}
+		purged += expired
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	next, err := newSnapshot(s.version+1, files)
+	if err != nil {
+		return 0, err
+	}
+	next.added, next.deleted = copyAdmin(s.added, s.deleted)
+	if err := s.swap(next, s.previous, nil); err != nil {
+		return 0, err
+	}
+	s.version = next.version
+	logger.infof("Purged %v expired records", purged)
+	purgedTotal.add(float64(purged))
+	return purged, nil
+}
+
+// sweepExpired sweeps the expired records every interval until stop is closed
+func (s *urlLookupServer) sweepExpired(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.sweep(expiryNow()); err != nil {
+				logger.errorf("Failed to purge the expired records: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test that the expired records stop matching, and that the sweeper purges
+// them from the store and the bucket files
+func TestSweepExpired(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour).Format(time.RFC3339)
+	future := now.Add(time.Hour).Format(time.RFC3339)
+	seen := now.Add(-2 * time.Hour).Format(time.RFC3339)
+
+	invalid := []URLDBEntry{
+		{HostAndPort: "www.ttl.com", OriginalPath: "a", Category: "ttl", TTL: "1h"},
+		{HostAndPort: "www.ttl.com", OriginalPath: "a", Category: "ttl", TTL: "soon", Verdict: Verdict{LastSeen: seen}},
+		{HostAndPort: "www.ttl.com", OriginalPath: "a", Category: "ttl", TTL: "1h", Verdict: Verdict{LastSeen: seen, Expires: future}},
+		{HostAndPort: "www.ttl.com", OriginalPath: "a", Category: "ttl", Verdict: Verdict{Expires: "tomorrow"}},
+	}
+	server := &urlLookupServer{store: newBucketStore(urlCachePath, 1, 1)}
+	for i := range invalid {
+		if err := server.addEntry(&invalid[i]); err == nil {
+			t.Errorf("Added an entry with an invalid expiry %v\n", invalid[i])
+		}
+	}
+
+	entries := []URLDBEntry{
+		{HostAndPort: "www.cnn.com", OriginalPath: "news", Category: "news"},
+		{HostAndPort: "www.cnn.com", OriginalPath: "old", Category: "expired", Verdict: Verdict{Expires: past}},
+		{HostAndPort: "www.cnn.com", OriginalPath: "new", Category: "expiring", Verdict: Verdict{Expires: future}},
+		{HostAndPort: "www.cnn.com", OriginalPath: "ttl", Category: "expired-ttl", TTL: "1h", Verdict: Verdict{LastSeen: seen}},
+		{HostAndPort: "www.cnn.com", OriginalPath: "long-ttl", Category: "ttl", TTL: "3h", Verdict: Verdict{FirstSeen: seen}},
+		{HostAndPort: "*.evil.com", OriginalPath: "*", Category: "expired-rule", Verdict: Verdict{Expires: past}},
+	}
+	// Build the snapshot from the records as they are, as the expired records
+	// of the configuration files are skipped when they are read
+	parsed := make(fileEntries)
+	for i := range entries {
+		url, info, err := parseEntry(&entries[i], nil)
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v\n", entries[i], err)
+			continue
+		}
+		parsed[entryKey{url: url}] = info
+	}
+	file := newPinnedFile("", parsed)
+	// Swap it in twice, so that there is a previous snapshot
+	server.reloadLock.Lock()
+	for version := 1; version <= 2; version++ {
+		if err := server.reload(map[string]*configFile{"urlcfg1.json": file}); err != nil {
+			t.Errorf("Failed to swap in the snapshot: %v\n", err)
+		}
+	}
+	server.reloadLock.Unlock()
+
+	urls := []URL{
+		{"www.cnn.com:80", "news"},
+		{"www.cnn.com:80", "old"},
+		{"www.cnn.com:80", "new"},
+		{"www.cnn.com:80", "ttl"},
+		{"www.cnn.com:80", "long-ttl"},
+		{"www.evil.com:80", "index.html"},
+	}
+	expected := []string{"news", "Unknown", "expiring", "Unknown", "ttl", "Unknown"}
+	if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Looked up %v, expected %v\n", categories, expected)
+	}
+	if info := server.snapshots().Current; info.Expired != 3 {
+		t.Errorf("Snapshot has %v expired records, expected 3\n", info.Expired)
+	}
+
+	if purged, err := server.sweep(expiryNow()); err != nil || purged != 3 {
+		t.Errorf("Purged %v expired records, expected 3: %v\n", purged, err)
+	}
+	stored := make(URLDB)
+	server.store.Iterate(func(url URL, info *URLInfo) error {
+		stored[url] = info
+		return nil
+	})
+	found := make(URLDB)
+	if err := readBucketFile(filepath.Join(urlCachePath, "bucket0.json"), found); err != nil {
+		t.Errorf("Failed to read the bucket file: %v\n", err)
+	}
+	if len(stored) != 3 || found[urls[1]] != nil || found[urls[3]] != nil {
+		t.Errorf("Store has %v, and the bucket file %v after the sweep\n", stored, found)
+	}
+
+	// The expiring records are purged later on, and the sweeps keep the
+	// snapshot before the last change for a rollback
+	later := now.Add(90 * time.Minute).Format(time.RFC3339)
+	if purged, err := server.sweep(later); err != nil || purged != 2 {
+		t.Errorf("Purged %v expired records, expected 2: %v\n", purged, err)
+	}
+	if purged, err := server.sweep(later); err != nil || purged != 0 {
+		t.Errorf("Purged %v expired records again: %v\n", purged, err)
+	}
+	snapshots := server.snapshots()
+	if snapshots.Current.URLs != 1 || snapshots.Previous == nil || snapshots.Previous.Version != 1 {
+		t.Errorf("Snapshots are %v and %v after the sweeps\n", *snapshots.Current, snapshots.Previous)
+	}
+}
+
+// Test that the expired records of a configuration file are skipped
+func TestLoadExpired(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	writeURLs(t, filepath.Join(urlCfgPath, "urlcfg1.json"),
+		URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news"},
+		URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "old", Category: "news", Verdict: Verdict{Expires: past}})
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: newMemStore()}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load URLs: %v\n", err)
+	}
+	if info := server.snapshots().Current; info.URLs != 1 || info.Expired != 0 {
+		t.Errorf("Loaded %v, expected 1 URL\n", *info)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// Category defines a category of the taxonomy
+type Category struct {
+	// ID is the canonical name of the category in the responses
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Parent is the ID of the parent category, if any
+	Parent string `json:"parent,omitempty"`
+	// Safe is the safety of the URLs of the category whose records don't
+	// have one
+	Safe bool `json:"safe"`
+	// Aliases are the other names of the category in the url records
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Taxonomy defines the categories that the url records may have
+type Taxonomy struct {
+	Categories []Category `json:"categories"`
+}
+
+// taxonomy is a loaded taxonomy, indexed by category
+type taxonomy struct {
+	*Taxonomy
+	digest     string
+	categories map[string]*Category
+	// names maps the lowercased IDs and aliases to the IDs
+	names map[string]string
+}
+
+// Read and validate a taxonomy file. The IDs and aliases must be unique, and
+// the parents must exist without making a cycle.
+func loadTaxonomy(path string) (*taxonomy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tax := &taxonomy{
+		Taxonomy:   &Taxonomy{},
+		digest:     fmt.Sprintf("%x", sha256.Sum256(data)),
+		categories: make(map[string]*Category),
+		names:      make(map[string]string),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(tax.Taxonomy); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for i := range tax.Categories {
+		category := &tax.Categories[i]
+		if category.ID == "" || strings.Contains(category.ID, "/") {
+			return nil, fmt.Errorf("%s: invalid category ID '%v'", path, category.ID)
+		}
+		for _, name := range append([]string{category.ID}, category.Aliases...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if id, ok := tax.names[key]; ok {
+				return nil, fmt.Errorf("%s: '%v' names both %v and %v", path, name, id, category.ID)
+			}
+			tax.names[key] = category.ID
+		}
+		tax.categories[category.ID] = category
+	}
+	for _, category := range tax.categories {
+		seen := map[string]bool{category.ID: true}
+		for parent := category.Parent; parent != ""; parent = tax.categories[parent].Parent {
+			if tax.categories[parent] == nil {
+				return nil, fmt.Errorf("%s: unknown parent '%v' of %v", path, parent, category.ID)
+			}
+			if seen[parent] {
+				return nil, fmt.Errorf("%s: %v is its own ancestor", path, category.ID)
+			}
+			seen[parent] = true
+		}
+	}
+	logger.infof("Read %v categories from %s", len(tax.Categories), path)
+	return tax, nil
+}
+
+// resolve maps the category names of a url record to their IDs, without
+// duplicates. Without a taxonomy, the names are kept as they are.
+func (tax *taxonomy) resolve(names []string) ([]string, error) {
+	ids := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		id := name
+		if tax != nil {
+			var ok bool
+			if id, ok = tax.names[strings.ToLower(strings.TrimSpace(name))]; !ok {
+				return nil, fmt.Errorf("unknown category '%v'", name)
+			}
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// safe tells the default safety of the URLs with the categories, which is safe
+// only if all of them are
+func (tax *taxonomy) safe(ids []string) bool {
+	if tax == nil {
+		return false
+	}
+	for _, id := range ids {
+		if !tax.categories[id].Safe {
+			return false
+		}
+	}
+	return true
+}
+
+// path returns the IDs of a category and its ancestors from the root, joined
+// by "/"
+func (tax *taxonomy) path(id string) string {
+	if tax == nil || tax.categories[id] == nil {
+		return id
+	}
+	path := []string{id}
+	for parent := tax.categories[id].Parent; parent != ""; parent = tax.categories[parent].Parent {
+		path = append([]string{parent}, path...)
+	}
+	return strings.Join(path, "/")
+}
+
+func (s *urlLookupServer) getTaxonomy(request *restful.Request, response *restful.Response) {
+	taxonomy := &Taxonomy{Categories: []Category{}}
+	if s.taxonomy != nil {
+		taxonomy = s.taxonomy.Taxonomy
+	}
+	if err := response.WriteEntity(taxonomy); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
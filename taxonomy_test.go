@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test that the taxonomy maps the categories of the sample configuration files,
+// and gives the default safety and the category paths
+func TestTaxonomy(t *testing.T) {
+	tax, err := loadTaxonomy("taxonomy.json")
+	if err != nil {
+		t.Errorf("Failed to load the taxonomy: %v\n", err)
+		return
+	}
+	server := &urlLookupServer{urlCfgPath: "sample-cfgs", store: newMemStore(), taxonomy: tax}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load the sample configuration files: %v\n", err)
+	}
+	info := &URLInfo{}
+	serveRequest(server, "GET", "/urlinfo/2/www.linkedin.com:80/profile", "", "", info)
+	if info.Category != "jobs" || !reflect.DeepEqual(info.CategoryPaths, []string{"business/jobs"}) {
+		t.Errorf("Looked up %v, expected the jobs category\n", *info)
+	}
+
+	entries := []URLDBEntry{
+		{HostAndPort: "www.indeed.com", OriginalPath: "jobs", Category: "Recruiter", Categories: []string{"high-tech", "jobs"}},
+		{HostAndPort: "www.evil.com", OriginalPath: "jobs", Categories: []string{"jobs", "bad-site"}},
+		{HostAndPort: "www.good.com", OriginalPath: "bombs", Category: "terrorism", Safe: boolPtr(true)},
+	}
+	for i := range entries {
+		if err := server.addEntry(&entries[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entries[i], err)
+		}
+	}
+	tests := []struct {
+		path  string
+		safe  bool
+		paths []string
+	}{
+		{"/urlinfo/2/www.indeed.com/jobs", true, []string{"business/jobs", "business/technology"}},
+		{"/urlinfo/2/www.evil.com/jobs", false, []string{"business/jobs", "threats/malicious"}},
+		{"/urlinfo/2/www.good.com/bombs", true, []string{"threats/violence/terrorism"}},
+	}
+	for _, test := range tests {
+		info := &URLInfo{}
+		serveRequest(server, "GET", test.path, "", "", info)
+		if info.Safe != test.safe || !reflect.DeepEqual(info.CategoryPaths, test.paths) {
+			t.Errorf("Looked up %v: %v, expected %v, %v\n", test.path, *info, test.safe, test.paths)
+		}
+	}
+
+	var fields map[string]interface{}
+	serveRequest(server, "GET", "/urlinfo/1/www.indeed.com/jobs", "", "", &fields)
+	if len(fields) != 2 || fields["category"] != "jobs" {
+		t.Errorf("Looked up %v with version 1\n", fields)
+	}
+
+	unknown := &URLDBEntry{HostAndPort: "www.cnn.com", OriginalPath: "weather", Category: "weather"}
+	if err := server.addEntry(unknown); err == nil {
+		t.Errorf("Added a record with an unknown category\n")
+	}
+}
+
+// Test that invalid taxonomies are rejected
+func TestInvalidTaxonomy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taxonomy")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	taxonomies := []string{
+		`{"categories": [{"id": "news", "parent": "media"}]}`,
+		`{"categories": [{"id": "a", "parent": "b"}, {"id": "b", "parent": "a"}]}`,
+		`{"categories": [{"id": "news"}, {"id": "media", "aliases": ["News"]}]}`,
+		`{"categories": [{"id": "news/local"}]}`,
+		`{"categories": [{"id": "news", "safety": true}]}`,
+	}
+	path := filepath.Join(dir, "taxonomy.json")
+	for _, taxonomy := range taxonomies {
+		if err := ioutil.WriteFile(path, []byte(taxonomy), 0666); err != nil {
+			t.Errorf("Failed to write to file %v: %v\n", path, err)
+			continue
+		}
+		if _, err := loadTaxonomy(path); err == nil {
+			t.Errorf("Loaded an invalid taxonomy %v\n", taxonomy)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+const (
+	// Number of rows of the count-min sketch
+	sketchDepth = 4
+	// Maximum value of a sketch counter
+	sketchMaxCount = 15
+	// The frequencies are halved after this many increments per cached entry
+	sketchSamplesPerEntry = 10
+)
+
+// countMinSketch estimates the access frequencies of URLs with small counters.
+// All counters are halved periodically, so that old accesses decay.
+type countMinSketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint32
+	samples int
+	resetAt int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:    uint32(width - 1),
+		resetAt: sketchSamplesPerEntry * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) indexes(url URL) [sketchDepth]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(url.hostAndPort))
+	h.Write([]byte{'/'})
+	h.Write([]byte(url.originalPath))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	var indexes [sketchDepth]uint32
+	for i := range indexes {
+		indexes[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return indexes
+}
+
+func (s *countMinSketch) increment(url URL) {
+	for i, index := range s.indexes(url) {
+		if s.rows[i][index] < sketchMaxCount {
+			s.rows[i][index]++
+		}
+	}
+	s.samples++
+	if s.samples >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(url URL) uint8 {
+	min := uint8(sketchMaxCount)
+	for i, index := range s.indexes(url) {
+		if s.rows[i][index] < min {
+			min = s.rows[i][index]
+		}
+	}
+	return min
+}
+
+// Halve all the counters
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.samples /= 2
+}
+
+const (
+	windowSegment = iota
+	probationSegment
+	protectedSegment
+)
+
+// cacheEntry is a URL in the cache. A dirty entry has not been saved in the
+// persistent tier yet.
+type cacheEntry struct {
+	url     URL
+	info    *URLInfo
+	dirty   bool
+	segment int
+}
+
+// tinyLFUCache is a W-TinyLFU cache. New entries go to a small LRU window. An
+// entry leaving the window is admitted to the main segmented LRU only if it's
+// accessed more often than the entry it would evict. Main entries accessed
+// again are promoted from probation to protected. It is not safe for
+// concurrent use.
+type tinyLFUCache struct {
+	capacity     int
+	windowCap    int
+	protectedCap int
+	sketch       *countMinSketch
+	items        map[URL]*list.Element
+	window       *list.List
+	probation    *list.List
+	protected    *list.List
+}
+
+func newTinyLFUCache(capacity int) *tinyLFUCache {
+	// 1% of the capacity for the window, and 80% of the rest for protected
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	return &tinyLFUCache{
+		capacity:     capacity,
+		windowCap:    windowCap,
+		protectedCap: (capacity - windowCap) * 8 / 10,
+		sketch:       newCountMinSketch(capacity),
+		items:        make(map[URL]*list.Element),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+	}
+}
+
+func (c *tinyLFUCache) segment(segment int) *list.List {
+	switch segment {
+	case windowSegment:
+		return c.window
+	case probationSegment:
+		return c.probation
+	default:
+		return c.protected
+	}
+}
+
+// Look up a URL and record the access
+func (c *tinyLFUCache) get(url URL) (*cacheEntry, bool) {
+	c.sketch.increment(url)
+	elem, ok := c.items[url]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	switch entry.segment {
+	case probationSegment:
+		// Promote it, and demote the least recently used protected entry
+		c.probation.Remove(elem)
+		entry.segment = protectedSegment
+		c.items[url] = c.protected.PushFront(entry)
+		if c.protected.Len() > c.protectedCap {
+			oldest := c.protected.Back()
+			demoted := c.protected.Remove(oldest).(*cacheEntry)
+			demoted.segment = probationSegment
+			c.items[demoted.url] = c.probation.PushFront(demoted)
+		}
+	default:
+		c.segment(entry.segment).MoveToFront(elem)
+	}
+	return entry, true
+}
+
+// Look up a URL without recording the access
+func (c *tinyLFUCache) peek(url URL) (*cacheEntry, bool) {
+	elem, ok := c.items[url]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*cacheEntry), true
+}
+
+// Add or replace a URL, and return the entries evicted to make room for it,
+// which may be the new entry itself if it's not admitted. Only get records the
+// accesses.
+func (c *tinyLFUCache) add(url URL, info *URLInfo, dirty bool) []*cacheEntry {
+	if elem, ok := c.items[url]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.info = info
+		entry.dirty = entry.dirty || dirty
+		c.segment(entry.segment).MoveToFront(elem)
+		return nil
+	}
+
+	c.items[url] = c.window.PushFront(&cacheEntry{url: url, info: info, dirty: dirty, segment: windowSegment})
+	if c.window.Len() <= c.windowCap {
+		return nil
+	}
+
+	// The least recently used window entry is the candidate for the main
+	// segments
+	candidate := c.window.Remove(c.window.Back()).(*cacheEntry)
+	candidate.segment = probationSegment
+	c.items[candidate.url] = c.probation.PushFront(candidate)
+	if len(c.items) <= c.capacity {
+		return nil
+	}
+
+	// Keep the candidate only if it's used more often than the victim, the
+	// least recently used probation entry
+	victimElem := c.probation.Back()
+	if victimElem.Value.(*cacheEntry) == candidate {
+		victimElem = c.protected.Back()
+	}
+	if victimElem != nil && c.sketch.estimate(candidate.url) > c.sketch.estimate(victimElem.Value.(*cacheEntry).url) {
+		return []*cacheEntry{c.evict(victimElem)}
+	}
+	return []*cacheEntry{c.evict(c.items[candidate.url])}
+}
+
+func (c *tinyLFUCache) evict(elem *list.Element) *cacheEntry {
+	entry := c.segment(elem.Value.(*cacheEntry).segment).Remove(elem).(*cacheEntry)
+	delete(c.items, entry.url)
+	return entry
+}
+
+func (c *tinyLFUCache) remove(url URL) {
+	if elem, ok := c.items[url]; ok {
+		c.evict(elem)
+	}
+}
+
+// Call fn with every cached entry
+func (c *tinyLFUCache) each(fn func(entry *cacheEntry)) {
+	for _, elem := range c.items {
+		fn(elem.Value.(*cacheEntry))
+	}
+}
+
+func (c *tinyLFUCache) len() int {
+	return len(c.items)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+const (
+	zipfURLs     = 10000
+	zipfLookups  = 200000
+	zipfCapacity = 1000
+	zipfBuckets  = 31
+)
+
+// A Zipf distributed workload. The popularity of a URL doesn't depend on its host.
+func zipfWorkload() ([]URL, []int) {
+	r := rand.New(rand.NewSource(1))
+	urls := make([]URL, zipfURLs)
+	for i, j := range r.Perm(zipfURLs) {
+		urls[i] = URL{hostAndPort: fmt.Sprintf("www.host%v.com:80", j/4), originalPath: fmt.Sprintf("path%v", j%4)}
+	}
+	zipf := rand.NewZipf(r, 1.1, 1, zipfURLs-1)
+	lookups := make([]int, zipfLookups)
+	for i := range lookups {
+		lookups[i] = int(zipf.Uint64())
+	}
+	return urls, lookups
+}
+
+// vacateSim models the former URL cache, which vacated the whole bucket hit the
+// least when the cache was full, and loaded a vacated bucket back when it was
+// looked up.
+type vacateSim struct {
+	capacity int
+	cached   int
+	hits     []int
+	vacated  []bool
+	loaded   []int
+	urls     [][]URL
+}
+
+func newVacateSim(urls []URL) *vacateSim {
+	s := &vacateSim{
+		capacity: zipfCapacity,
+		hits:     make([]int, zipfBuckets),
+		vacated:  make([]bool, zipfBuckets),
+		loaded:   make([]int, zipfBuckets),
+		urls:     make([][]URL, zipfBuckets),
+	}
+	for _, url := range urls {
+		bucketNo := hash(url.hostAndPort, zipfBuckets)
+		s.urls[bucketNo] = append(s.urls[bucketNo], url)
+	}
+	// Load all the URLs as the server does at startup
+	for bucketNo := range s.urls {
+		for range s.urls[bucketNo] {
+			s.add(bucketNo)
+		}
+	}
+	return s
+}
+
+func (s *vacateSim) vacate(bno int) int {
+	bucketNo, hit, urlCount := bno, 0, 0
+	for i := range s.loaded {
+		if s.loaded[i] == 0 {
+			continue
+		}
+		if urlCount == 0 || s.hits[i] < hit || (s.hits[i] == hit && s.loaded[i] > urlCount) {
+			bucketNo, hit, urlCount = i, s.hits[i], s.loaded[i]
+		}
+	}
+	s.cached -= s.loaded[bucketNo]
+	s.loaded[bucketNo] = 0
+	s.hits[bucketNo] = 0
+	s.vacated[bucketNo] = true
+	return bucketNo
+}
+
+func (s *vacateSim) add(bucketNo int) {
+	if s.vacated[bucketNo] {
+		return
+	}
+	if s.cached >= s.capacity && s.vacate(bucketNo) == bucketNo {
+		return
+	}
+	s.loaded[bucketNo]++
+	s.cached++
+}
+
+func (s *vacateSim) lookup(url URL) bool {
+	bucketNo := hash(url.hostAndPort, zipfBuckets)
+	s.hits[bucketNo]++
+	if !s.vacated[bucketNo] {
+		return true
+	}
+	s.vacated[bucketNo] = false
+	for range s.urls[bucketNo] {
+		s.add(bucketNo)
+	}
+	return false
+}
+
+func vacateHitRatio(urls []URL, lookups []int) float64 {
+	s := newVacateSim(urls)
+	hits := 0
+	for _, i := range lookups {
+		if s.lookup(urls[i]) {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(lookups))
+}
+
+func lruHitRatio(urls []URL, lookups []int) float64 {
+	c := newLRUCache(zipfCapacity)
+	hits := 0
+	for _, i := range lookups {
+		if _, ok := c.get(urls[i]); ok {
+			hits++
+			continue
+		}
+		c.add(urls[i], notFound)
+	}
+	return float64(hits) / float64(len(lookups))
+}
+
+func tinyLFUHitRatio(urls []URL, lookups []int) float64 {
+	c := newTinyLFUCache(zipfCapacity)
+	hits := 0
+	for _, i := range lookups {
+		if _, ok := c.get(urls[i]); ok {
+			hits++
+			continue
+		}
+		c.add(urls[i], notFound, false)
+	}
+	return float64(hits) / float64(len(lookups))
+}
+
+func TestTinyLFUCache(t *testing.T) {
+	c := newTinyLFUCache(100)
+	hot := URL{"www.hot.com:80", "index.html"}
+	c.add(hot, &URLInfo{Category: "hot"}, true)
+	for i := 0; i < 10; i++ {
+		c.get(hot)
+	}
+
+	// A scan of URLs used once doesn't evict the hot one
+	evicted := 0
+	for i := 0; i < 1000; i++ {
+		url := URL{fmt.Sprintf("www.scan%v.com:80", i), "index.html"}
+		c.get(url)
+		for _, entry := range c.add(url, &URLInfo{Category: "scan"}, false) {
+			if entry.url == hot {
+				t.Errorf("Hot url %v has been evicted\n", hot)
+			}
+			evicted++
+		}
+	}
+	if c.len() != 100 || evicted != 901 {
+		t.Errorf("Cached %v urls and evicted %v, expected 100 and 901\n", c.len(), evicted)
+	}
+	if entry, ok := c.peek(hot); !ok || !entry.dirty {
+		t.Errorf("Hot url %v is not cached as dirty\n", hot)
+	}
+}
+
+// Test that the per URL eviction beats the whole bucket vacate on a Zipf workload
+func TestHitRatio(t *testing.T) {
+	urls, lookups := zipfWorkload()
+	vacate, lru, tinyLFU := vacateHitRatio(urls, lookups), lruHitRatio(urls, lookups), tinyLFUHitRatio(urls, lookups)
+	t.Logf("Hit ratios: bucket vacate %.3f, LRU %.3f, W-TinyLFU %.3f\n", vacate, lru, tinyLFU)
+	if tinyLFU <= vacate || tinyLFU <= lru {
+		t.Errorf("W-TinyLFU hit ratio %.3f is not better than bucket vacate %.3f and LRU %.3f\n", tinyLFU, vacate, lru)
+	}
+}
+
+func benchmarkHitRatio(b *testing.B, hitRatio func([]URL, []int) float64) {
+	urls, lookups := zipfWorkload()
+	b.ResetTimer()
+	ratio := 0.0
+	for i := 0; i < b.N; i++ {
+		ratio = hitRatio(urls, lookups)
+	}
+	b.ReportMetric(ratio, "hit-ratio")
+}
+
+func BenchmarkHitRatioBucketVacate(b *testing.B) {
+	benchmarkHitRatio(b, vacateHitRatio)
+}
+
+func BenchmarkHitRatioLRU(b *testing.B) {
+	benchmarkHitRatio(b, lruHitRatio)
+}
+
+func BenchmarkHitRatioTinyLFU(b *testing.B) {
+	benchmarkHitRatio(b, tinyLFUHitRatio)
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
-	"hash/fnv"
-	"io/ioutil"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc"
 )
 
 // URL defines a URL
@@ -22,18 +26,52 @@ type URL struct {
 	originalPath string
 }
 
+// Verdict defines why a URL is unsafe, how confident the verdict is, and where
+// and when it was seen. All its fields are optional.
+type Verdict struct {
+	// ThreatType is one of "phishing", "malware", "c2" or "adult"
+	ThreatType string `json:"threat_type,omitempty"`
+	// RiskScore is the confidence of the verdict, from 1 to 100, or 0 if unknown
+	RiskScore int `json:"risk_score,omitempty"`
+	// Source is the feed that supplied the verdict
+	Source string `json:"source,omitempty"`
+	// FirstSeen and LastSeen are RFC 3339 times in UTC
+	FirstSeen string `json:"first_seen,omitempty"`
+	LastSeen  string `json:"last_seen,omitempty"`
+	// Expires is the RFC 3339 time in UTC from which the record stops matching
+	Expires string `json:"expires,omitempty"`
+}
+
 // URLInfo defines information for a URL
 type URLInfo struct {
-	Category string `json:"category"`
-	Safe     bool   `json:"safe"`
+	// Category is the first of the categories of a URL
+	Category   string   `json:"category"`
+	Categories []string `json:"categories,omitempty"`
+	// CategoryPaths are the paths of the categories in the taxonomy
+	CategoryPaths []string `json:"category_paths,omitempty"`
+	Safe          bool     `json:"safe"`
+	// Found tells a URL looked up in the database from one that is not in it
+	Found bool `json:"found"`
+	Verdict
+	// Rule is the database rule that matched a looked up URL
+	Rule string `json:"rule,omitempty"`
 }
 
 // URLDBEntry defines a url record
 type URLDBEntry struct {
 	HostAndPort  string `json:"host"`
 	OriginalPath string `json:"path"`
-	Category     string `json:"category"`
-	Safe         bool   `json:"safe"`
+	// Match is either "exact", the default, or "prefix" for the path
+	Match string `json:"match,omitempty"`
+	// A record has a category, or a list of categories, or both
+	Category   string   `json:"category"`
+	Categories []string `json:"categories,omitempty"`
+	// Safe defaults to the safety of the categories in the taxonomy
+	Safe *bool `json:"safe,omitempty"`
+	Verdict
+	// TTL is how long the record matches after it was last seen, or first
+	// seen, such as "72h". It's an alternative to expires.
+	TTL string `json:"ttl,omitempty"`
 }
 
 // URLs defines a list of records
@@ -45,11 +83,32 @@ type URLs struct {
 // URLDB stores URLs and their information
 type URLDB map[URL]*URLInfo
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// equal tells if two URLs have the same information
+func (info *URLInfo) equal(other *URLInfo) bool {
+	return reflect.DeepEqual(info, other)
+}
+
+// expired tells if a URL has expired at now, an RFC 3339 time in UTC. Such
+// times of the same length sort like strings.
+func (info *URLInfo) expired(now string) bool {
+	return info.Expires != "" && info.Expires <= now
+}
+
+// The current time in the format of the expiry times
+func expiryNow() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
 const (
 	hostNameAndPort            = "host-name-and-port"
 	originalPathAndQueryString = "original-path-and-query-string"
-	hashTableSize              = 31
-	maxUrlsCached              = 100
+	matchExact                 = "exact"
+	matchPrefix                = "prefix"
+	maxRiskScore               = 100
 )
 
 var (
@@ -57,224 +116,400 @@ var (
 	supportedExtensions = map[string]bool{
 		".json": true,
 	}
+	threatTypes = map[string]bool{
+		"phishing": true,
+		"malware":  true,
+		"c2":       true,
+		"adult":    true,
+	}
 	notFound = &URLInfo{
 		Category: "Unknown",
 		Safe:     false,
 	}
 )
 
-type bucket struct {
-	hit      int
-	lock     sync.Mutex
-	urldb    URLDB
-	fileName string
-}
-
-// URLHashTbl is a hash table in which each bucket contains a map of URLs
-type URLHashTbl [hashTableSize]bucket
-
 type urlLookupServer struct {
 	httpPort     int
 	urlCfgPath   string
 	urlCachePath string
-	cachedCount  int
-	maxHit       int
-	urlht        URLHashTbl
-	lock         sync.Mutex
+	store        Store
+	// taxonomy is nil if the categories are free-form
+	taxonomy *taxonomy
+	// adminToken is the bearer token of the admin API, which is disabled
+	// without one
+	adminToken string
+	// requireClientCert tells if the requests need a verified client
+	// certificate, and apiKeys are the keys they need, if any
+	requireClientCert bool
+	apiKeys           *apiKeys
+	// rateLimiter limits the requests of the clients, if set
+	rateLimiter *rateLimiter
+	// accessLog records the lookups and the changes of the admin API, if set
+	accessLog *accessLog
+	// grpcServer serves the gRPC API of the lookups, if enabled
+	grpcServer *grpc.Server
+	// extAuthz are the options of the Envoy external authorization API,
+	// which is disabled if nil
+	extAuthz *extAuthzOptions
+	// reloadLock serializes the reloads, and snapshotLock guards the swap of
+	// the snapshots against the lookups
+	reloadLock   sync.Mutex
+	snapshotLock sync.RWMutex
+	version      int
+	current      *snapshot
+	previous     *snapshot
+	// pending is the delta of the current snapshot that the store may not
+	// have yet, which the lookups see first
+	pending *storeDelta
+	// stash has the versions of the configuration files of the snapshots.
+	// reloadLock guards it.
+	stash *configStash
+	// loaded tells if all the configuration files have been loaded, and
+	// loadErr is why the last load failed. reloadLock guards them.
+	loaded  bool
+	loadErr error
+	// draining is set when the server is shutting down. reloadLock guards it.
+	draining bool
+	// added are the url records added with the admin API by key, and
+	// deleted the keys of the records of the files deleted with it, which
+	// journal persists. reloadLock guards them.
+	added   map[entryKey]URLDBEntry
+	deleted map[entryKey]bool
+	journal *journal
+	// watching is 1 while the watcher goroutine runs, which closes
+	// watcherDone when it exits
+	watching    int32
+	watcher     *fsnotify.Watcher
+	watcherDone chan struct{}
 }
 
-func hash(s string) int {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return int(h.Sum32() % hashTableSize)
+// Look up URLs in the store, and fall back to the rules for their hosts for the
+// ones not in the store
+func (s *urlLookupServer) lookup(urls []URL) ([]*URLInfo, error) {
+	urlinfos, _, err := s.lookupLoaded(urls)
+	return urlinfos, err
 }
 
-// Save a bucket that is getting hit the least to a file, and get room for the new item
-func (s *urlLookupServer) vacate(bno int, url *URL, info *URLInfo) (int, error) {
-	s.lock.Lock()
-	hit := s.maxHit
-	bucketNo := 0
-	urlCount := 0
-	for i := 0; i < hashTableSize; i++ {
-		if s.urlht[i].hit <= hit && len(s.urlht[i].urldb) > urlCount {
-			bucketNo = i
-			hit = s.urlht[i].hit
-			urlCount = len(s.urlht[i].urldb)
-		}
-	}
-	s.cachedCount = s.cachedCount - len(s.urlht[bucketNo].urldb)
-	s.urlht[bucketNo].hit = 0
-	s.lock.Unlock()
-
-	log.Printf("Vacate bucket '%v' with %v urls\n", bucketNo, urlCount)
-	// Vacate this bucket
-	bucket := &s.urlht[bucketNo]
-	bucket.lock.Lock()
-
-	// if it's the same bucket, add it to the map first
-	if url != nil && bno == bucketNo {
-		bucket.urldb[*url] = info
+// lookupLoaded is lookup that also tells which URLs were loaded from the disk,
+// if the store keeps them there
+func (s *urlLookupServer) lookupLoaded(urls []URL) ([]*URLInfo, []bool, error) {
+	defer lookupSeconds.since(time.Now())
+	s.snapshotLock.RLock()
+	defer s.snapshotLock.RUnlock()
+	var infos []*URLInfo
+	var loaded []bool
+	var err error
+	if store, ok := s.store.(diskStore); ok {
+		infos, loaded, err = store.GetLoaded(urls)
+	} else {
+		infos, err = s.store.Get(urls)
 	}
-
-	entries := &URLs{
-		URLEntries: make([]URLDBEntry, len(bucket.urldb)),
+	if err != nil {
+		return nil, nil, err
 	}
-	index := 0
-	for url1, info := range bucket.urldb {
-		log.Printf("url1: %v, info: %v", url1, *info)
-		entries.URLEntries[index].HostAndPort = url1.hostAndPort
-		entries.URLEntries[index].OriginalPath = url1.originalPath
-		entries.URLEntries[index].Category = info.Category
-		entries.URLEntries[index].Safe = info.Safe
-		index++
-		delete(bucket.urldb, url1)
+	if s.pending != nil {
+		for i, url := range urls {
+			if info, ok := s.pending.changed[url]; ok {
+				infos[i] = info
+			} else if s.pending.removed[url] {
+				infos[i] = nil
+			}
+		}
 	}
 
-	data, err := json.Marshal(entries)
-	if err != nil {
-		log.Printf("failed to Marshall: %v\n", err)
-		return 0, err
-	}
-	if err = ioutil.WriteFile(bucket.fileName, data, 0666); err != nil {
-		log.Printf("failed to Marshall: %v\n", err)
-		return 0, err
+	now := expiryNow()
+	urlinfos := make([]*URLInfo, len(urls))
+	for i, url := range urls {
+		if infos[i] != nil && infos[i].expired(now) {
+			// The sweeper hasn't purged it yet
+			expiredLookupsTotal.inc()
+			infos[i] = nil
+		}
+		if infos[i] == nil {
+			// Fall back to the rules for the host and its parent domains
+			urlinfos[i] = s.matchHost(url, now)
+			continue
+		}
+		matched := *infos[i]
+		matched.Found = true
+		matched.Rule = url.hostAndPort + "/" + url.originalPath
+		urlinfos[i] = &matched
 	}
-	bucket.lock.Unlock()
-	return bucketNo, nil
+	countLookups(urlinfos)
+	return urlinfos, loaded, nil
 }
 
-func (s *urlLookupServer) addToCache(url *URL, info *URLInfo) error {
-	// Maximum cache capacity reached.
-	bucketNo := hash(url.hostAndPort)
-	if s.cachedCount >= maxUrlsCached {
-		bno, err := s.vacate(bucketNo, url, info)
-		if err != nil {
-			return err
-		}
+// URLInfoV1 is the information of a URL returned by the version 1 of the API
+type URLInfoV1 struct {
+	Category string `json:"category"`
+	Safe     bool   `json:"safe"`
+}
 
-		// The bucket for this item is the same as the one vacated
-		// The item has been added
-		if bno == bucketNo {
-			return nil
-		}
+// infoView converts the information of a URL for a version of the API
+type infoView func(info *URLInfo) interface{}
+
+// The version 1 of the API returns only the category and the safety of a URL
+func infoV1(info *URLInfo) interface{} {
+	return &URLInfoV1{Category: info.Category, Safe: info.Safe}
+}
 
+// The version 2 of the API returns the verdict of a URL in full, and the paths
+// of its categories
+func (s *urlLookupServer) infoV2(info *URLInfo) *URLInfo {
+	if s.taxonomy == nil || len(info.Categories) == 0 {
+		return info
 	}
-	// Add this item
-	log.Printf("add one url %v in bucket '%v'\n", url, bucketNo)
-	bucket := &s.urlht[bucketNo]
-	bucket.lock.Lock()
-	bucket.urldb[*url] = info
-	bucket.lock.Unlock()
-	s.lock.Lock()
-	s.cachedCount++
-	s.lock.Unlock()
-	return nil
+	withPaths := *info
+	withPaths.CategoryPaths = make([]string, len(info.Categories))
+	for i, id := range info.Categories {
+		withPaths.CategoryPaths[i] = s.taxonomy.path(id)
+	}
+	return &withPaths
 }
 
-func (s *urlLookupServer) lookupURL(request *restful.Request, response *restful.Response) {
-	host := request.PathParameter(hostNameAndPort)
-	original := request.PathParameter(originalPathAndQueryString)
+// lookupURL returns the route function of the lookups for a version of the API
+func (s *urlLookupServer) lookupURL(view infoView) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		start := time.Now()
+		host := request.PathParameter(hostNameAndPort)
+		original := request.PathParameter(originalPathAndQueryString)
 
-	url := URL{hostAndPort: host, originalPath: original}
-	bucketNo := hash(url.hostAndPort)
-	bucket := &s.urlht[bucketNo]
-	s.lock.Lock()
-	bucket.hit++
-	s.lock.Unlock()
+		if query := request.Request.URL.RawQuery; query != "" {
+			original = original + "?" + query
+		}
 
-	var err error
-	var urlinfo *URLInfo
-	log.Printf("Look up url %v in bucket '%v' with '%v' urls\n", url, bucketNo, len(bucket.urldb))
-	bucket.lock.Lock()
-	// Load the bucket
-	if len(bucket.urldb) == 0 {
-		bucket.lock.Unlock()
-		err = s.loadFromFile(bucket.fileName)
-		bucket.lock.Lock()
-	}
-
-	if err == nil {
-		urlinfo = bucket.urldb[url]
-		if urlinfo == nil {
-			urlinfo = notFound
+		url, err := canonicalize(URL{hostAndPort: host, originalPath: original})
+		if err != nil {
+			writeError(request, response, http.StatusBadRequest, errInvalidURL, err)
+			return
 		}
-	}
-	bucket.lock.Unlock()
-	if err != nil {
-		if err := response.WriteEntity("Internal error"); err != nil {
-			fmt.Printf("Failed to write entry: %v", err)
+		urlinfos, loaded, err := s.lookupLoaded([]URL{url})
+		if err != nil {
+			writeError(request, response, http.StatusServiceUnavailable, errStoreUnavailable, err)
+			return
 		}
-	} else {
-		if err := response.WriteEntity(urlinfo); err != nil {
-			fmt.Printf("Failed to write entry: %v", err)
+		s.logAccess(request, start, []URL{url}, urlinfos, loaded)
+		if err := response.WriteEntity(view(urlinfos[0])); err != nil {
+			logger.warnf("Failed to write entry: %v", err)
 		}
 	}
 }
 
+// Reload a changed configuration file
 func (s *urlLookupServer) loadFromFile(path string) error {
-	log.Printf("Loading from %v", path)
-	data, err := ioutil.ReadFile(path)
+	logger.infof("Loading from %v", path)
+	file, err := readConfigFile(path, path, s.taxonomy)
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
 	if err != nil {
-		log.Printf("Failed to read %s: %v", path, err)
+		reloadsTotal.inc(reloadRejected)
+		s.loadErr = err
 		return err
 	}
 
-	var urls URLs
-	if err = json.Unmarshal(data, &urls); err != nil {
-		log.Printf("Failed to unmarshal %s: %v", path, err)
-		return err
+	files := s.currentFiles()
+	files[path] = file
+	s.loadErr = s.reload(files)
+	return s.loadErr
+}
+
+// Retract the url records of a removed configuration file
+func (s *urlLookupServer) unloadFile(path string) error {
+	logger.infof("Unloading %v", path)
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	files := s.currentFiles()
+	if files[path] == nil {
+		return nil
 	}
+	delete(files, path)
+	s.loadErr = s.reload(files)
+	return s.loadErr
+}
 
-	log.Printf("Adding %v urls", len(urls.URLEntries))
-	for _, urlinfo := range urls.URLEntries {
-		url := URL{hostAndPort: urlinfo.HostAndPort, originalPath: urlinfo.OriginalPath}
-		info := &URLInfo{Category: urlinfo.Category, Safe: urlinfo.Safe}
-		err = s.addToCache(&url, info)
+// Canonicalize the URL of a url record, check its match, and map its categories
+// to the taxonomy
+func parseEntry(entry *URLDBEntry, tax *taxonomy) (URL, *URLInfo, error) {
+	if entry.HostAndPort == "" || entry.OriginalPath == "" {
+		return URL{}, nil, fmt.Errorf("missing host or path")
 	}
-	return nil
+	names := entry.Categories
+	if entry.Category != "" {
+		names = append([]string{entry.Category}, names...)
+	}
+	if len(names) == 0 {
+		return URL{}, nil, fmt.Errorf("missing category")
+	}
+	categories, err := tax.resolve(names)
+	if err != nil {
+		return URL{}, nil, err
+	}
+	safe := tax.safe(categories)
+	if entry.Safe != nil {
+		safe = *entry.Safe
+	}
+	url, err := canonicalize(URL{hostAndPort: entry.HostAndPort, originalPath: entry.OriginalPath})
+	if err != nil {
+		return url, nil, err
+	}
+	if entry.Match != "" && entry.Match != matchExact && entry.Match != matchPrefix {
+		return url, nil, fmt.Errorf("unknown match '%v'", entry.Match)
+	}
+	verdict, err := parseVerdict(entry.Verdict)
+	if err != nil {
+		return url, nil, err
+	}
+	if entry.TTL != "" {
+		if verdict.Expires, err = expiryOf(entry.TTL, verdict); err != nil {
+			return url, nil, err
+		}
+	}
+	return url, &URLInfo{Category: categories[0], Categories: categories, Safe: safe, Verdict: verdict}, nil
 }
 
-func (s *urlLookupServer) loadURLs() error {
-	err := filepath.Walk(s.urlCfgPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// Check the fields of a verdict, and convert its times to UTC
+func parseVerdict(verdict Verdict) (Verdict, error) {
+	if verdict.ThreatType != "" && !threatTypes[verdict.ThreatType] {
+		return verdict, fmt.Errorf("unknown threat type '%v'", verdict.ThreatType)
+	}
+	if verdict.RiskScore < 0 || verdict.RiskScore > maxRiskScore {
+		return verdict, fmt.Errorf("risk score %v is not between 0 and %v", verdict.RiskScore, maxRiskScore)
+	}
+	var seen [3]time.Time
+	for i, field := range []*string{&verdict.FirstSeen, &verdict.LastSeen, &verdict.Expires} {
+		if *field == "" {
+			continue
 		}
-		if !supportedExtensions[filepath.Ext(path)] || (info.Mode()&os.ModeType) != 0 {
-			return nil
+		t, err := time.Parse(time.RFC3339, *field)
+		if err != nil {
+			return verdict, err
 		}
+		seen[i] = t
+		*field = t.UTC().Format(time.RFC3339)
+	}
+	if !seen[0].IsZero() && !seen[1].IsZero() && seen[1].Before(seen[0]) {
+		return verdict, fmt.Errorf("last seen %v before first seen %v", verdict.LastSeen, verdict.FirstSeen)
+	}
+	return verdict, nil
+}
+
+// The expiry time of a record with a TTL, from when it was last seen, or first
+// seen
+func expiryOf(ttl string, verdict Verdict) (string, error) {
+	if verdict.Expires != "" {
+		return "", fmt.Errorf("both expires and ttl")
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil || duration <= 0 {
+		return "", fmt.Errorf("invalid ttl '%v'", ttl)
+	}
+	seen := verdict.LastSeen
+	if seen == "" {
+		seen = verdict.FirstSeen
+	}
+	if seen == "" {
+		return "", fmt.Errorf("ttl without last_seen or first_seen")
+	}
+	t, err := time.Parse(time.RFC3339, seen)
+	if err != nil {
+		return "", err
+	}
+	return t.Add(duration).UTC().Format(time.RFC3339), nil
+}
 
-		err = s.loadFromFile(path)
+// Load all the configuration files into a new snapshot. All the files are read
+// and validated before the snapshot is swapped in, so that a reload either
+// happens in full or not at all.
+func (s *urlLookupServer) loadURLs() error {
+	sources, err := configFiles(s.urlCfgPath)
+	if err != nil {
+		s.reloadLock.Lock()
+		s.loadErr = err
+		s.reloadLock.Unlock()
 		return err
-	})
+	}
+	files := make(map[string]*configFile)
+	for path, source := range sources {
+		if files[path], err = readConfigFile(path, source, s.taxonomy); err != nil {
+			break
+		}
+	}
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	if err != nil {
+		reloadsTotal.inc(reloadRejected)
+	} else {
+		err = s.reload(files)
+	}
+	s.loadErr = err
+	s.loaded = s.loaded || err == nil
 	return err
 }
 
+// isLoaded tells if all the configuration files have been loaded
+func (s *urlLookupServer) isLoaded() bool {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	return s.loaded
+}
+
+func (s *urlLookupServer) isWatching() bool {
+	return atomic.LoadInt32(&s.watching) == 1
+}
+
 func (s *urlLookupServer) watchForUpdate() error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
 	}
+	s.watcher, s.watcherDone = watcher, make(chan struct{})
+	atomic.StoreInt32(&s.watching, 1)
 	go func() {
+		defer close(s.watcherDone)
+		defer atomic.StoreInt32(&s.watching, 0)
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
-				log.Println("event:", event)
-				// In case of change, load the changed/added file
-				// Only support adding new files and new entries for now
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					log.Println("modified file:", event.Name)
-					if supportedExtensions[filepath.Ext(event.Name)] {
-						s.loadFromFile(event.Name)
+				logger.debugf("Event: %v", event)
+				path := filepath.Clean(event.Name)
+				name := filepath.Base(path)
+				if name == configMapDataDir {
+					// kubelet has swapped in a new version of the ConfigMap
+					if event.Op&fsnotify.Create == fsnotify.Create {
+						logger.infof("Updated ConfigMap: %v", s.urlCfgPath)
+						s.loadURLs()
+					}
+					continue
+				}
+				if strings.HasPrefix(name, ".") || !supportedExtensions[filepath.Ext(path)] {
+					continue
+				}
+				if !s.isLoaded() {
+					// Retry the initial load of all the files
+					s.loadURLs()
+					continue
+				}
+				switch {
+				case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+					// A renamed file is loaded again with the Create event of its new name
+					logger.infof("Removed file: %v", path)
+					s.unloadFile(path)
+				case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+					if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+						continue
 					}
+					logger.infof("Modified file: %v", path)
+					s.loadFromFile(path)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				log.Println("error:", err)
+				logger.errorf("Failed to watch for update: %v", err)
 			}
 		}
 	}()
@@ -282,66 +517,316 @@ func (s *urlLookupServer) watchForUpdate() error {
 	// Watch the URL configuration path
 	err = watcher.Add(s.urlCfgPath)
 	if err != nil {
+		watcher.Close()
 		return err
 	}
 	return nil
 }
 
-func newLookupServer(httpPort int, urlCfgPath, urlCachePath string, stop <-chan struct{}) error {
-	ulServer = &urlLookupServer{
-		httpPort:     httpPort,
-		urlCfgPath:   urlCfgPath,
-		urlCachePath: urlCachePath,
-		lock:         sync.Mutex{},
+// Stop the watcher, and wait for its go routine to exit
+func (s *urlLookupServer) stopWatching() {
+	if s.watcher == nil {
+		return
 	}
+	if err := s.watcher.Close(); err != nil {
+		logger.errorf("Failed to stop the watcher: %v", err)
+	}
+	<-s.watcherDone
+}
 
-	for i := 0; i < hashTableSize; i++ {
-		ulServer.urlht[i].hit = 0
-		ulServer.urlht[i].lock = sync.Mutex{}
-		ulServer.urlht[i].urldb = make(URLDB)
-		ulServer.urlht[i].fileName = fmt.Sprintf("%s/bucket%v.json", urlCachePath, i)
+func (s *urlLookupServer) getCache(request *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(s.store.Stats()); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
 	}
+}
 
-	container := restful.NewContainer()
-	ws := &restful.WebService{}
-	ws.Produces(restful.MIME_JSON)
-	ws.Route(ws.
-		GET(fmt.Sprintf("/urlinfo/1/{%s}/{%s}", hostNameAndPort, originalPathAndQueryString)).
-		To(ulServer.lookupURL).
-		Doc("URL lookup service").
-		Param(ws.PathParameter(hostNameAndPort, "Host name and port as <host>:<port>").DataType("string")).
-		Param(ws.PathParameter(originalPathAndQueryString, "Original path and query string").DataType("string")))
-	container.Add(ws)
+func (s *urlLookupServer) resizeCache(request *restful.Request, response *restful.Response) {
+	defer func() { s.logAudit(request, auditResize, nil, response.StatusCode()) }()
+	store, ok := s.store.(resizableStore)
+	if !ok {
+		writeError(request, response, http.StatusBadRequest, errNotResizable,
+			fmt.Errorf("store backend '%v' can't be resized", s.store.Stats().Backend))
+		return
+	}
 
-	httpAddr := fmt.Sprintf(":%v", httpPort)
-	httpServer := &http.Server{
-		Addr:    httpAddr,
-		Handler: container,
+	stats := s.store.Stats()
+	geometry := &CacheGeometry{Buckets: stats.Buckets, Capacity: stats.Capacity}
+	if err := request.ReadEntity(geometry); err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidRequest, err)
+		return
+	}
+
+	if err := store.Resize(geometry.Buckets, geometry.Capacity); err != nil {
+		writeError(request, response, http.StatusBadRequest, errInvalidRequest, err)
+		return
+	}
+	if err := response.WriteEntity(s.store.Stats()); err != nil {
+		logger.warnf("Failed to write entry: %v", err)
+	}
+}
+
+func newLookupServer(httpPort, grpcPort int, urlCfgPath, urlCachePath, taxonomyPath string, storeOpts *storeOptions,
+	authOpts *authOptions, rateOpts *rateLimitOptions, logOpts *logOptions, extAuthzOpts *extAuthzOptions,
+	sweepInterval, drainTimeout time.Duration, stop <-chan struct{}) (<-chan struct{}, error) {
+	var tax *taxonomy
+	if taxonomyPath != "" {
+		var err error
+		if tax, err = loadTaxonomy(taxonomyPath); err != nil {
+			return nil, err
+		}
+	}
+	var adminToken string
+	if authOpts.adminTokenFile != "" {
+		var err error
+		if adminToken, err = readAdminToken(authOpts.adminTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	var keys *apiKeys
+	if authOpts.apiKeysFile != "" {
+		var err error
+		if keys, err = loadAPIKeys(authOpts.apiKeysFile); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := newTLSConfig(authOpts)
+	if err != nil {
+		return nil, err
+	}
+	var extAuthz *extAuthzOptions
+	if extAuthzOpts.enabled {
+		if err := extAuthzOpts.validate(); err != nil {
+			return nil, err
+		}
+		extAuthz = extAuthzOpts
+	}
+	limiter, err := newRateLimiter(rateOpts)
+	if err != nil {
+		return nil, err
+	}
+	accessLog, err := openAccessLog(logOpts)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newStore(storeOpts, urlCachePath)
+	if err != nil {
+		return nil, err
+	}
+	journal, ops, err := openJournal(urlCachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ulServer = &urlLookupServer{
+		httpPort:     httpPort,
+		urlCfgPath:   urlCfgPath,
+		urlCachePath: urlCachePath,
+		store:        store,
+		taxonomy:     tax,
+		adminToken:   adminToken,
+		journal:      journal,
+		stash:        newConfigStash(urlCachePath),
+
+		requireClientCert: authOpts.clientCAFile != "",
+		apiKeys:           keys,
+		rateLimiter:       limiter,
+		accessLog:         accessLog,
+		extAuthz:          extAuthz,
+	}
+	if err := ulServer.replayJournal(ops); err != nil {
+		return nil, err
 	}
+	container := ulServer.newContainer()
 
 	// Create the listener for the web server
+	httpAddr := fmt.Sprintf(":%v", httpPort)
 	listener, err := net.Listen("tcp", httpAddr)
 	if err != nil {
-		log.Printf("Listen to port %v failed", httpPort)
-		return err
+		logger.errorf("Listen to port %v failed", httpPort)
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	var grpcListener net.Listener
+	if grpcPort > 0 {
+		if grpcListener, err = net.Listen("tcp", fmt.Sprintf(":%v", grpcPort)); err != nil {
+			logger.errorf("Listen to port %v failed", grpcPort)
+			listener.Close()
+			return nil, err
+		}
+		ulServer.grpcServer = ulServer.newGRPCServer(tlsConfig)
 	}
 
-	log.Println("Loading URLs...")
+	logger.infof("Loading URLs...")
 	// Load URLs from configuration files
 	if err := ulServer.loadURLs(); err != nil {
-		log.Printf("Failed to load URLs: %v", err)
+		logger.errorf("Failed to load URLs: %v", err)
 	}
 
-	log.Println("Starting to watch for update...")
+	logger.infof("Starting to watch for update...")
 	if err := ulServer.watchForUpdate(); err != nil {
+		logger.errorf("Failed to watch for update: %v", err)
+	}
+	if keys != nil {
+		if err := keys.watch(); err != nil {
+			logger.errorf("Failed to watch the API keys: %v", err)
+		}
+	}
+	if sweepInterval > 0 {
+		go ulServer.sweepExpired(sweepInterval, stop)
 	}
 
-	// Start a go routine to serve http requests
+	if grpcListener != nil {
+		go func() {
+			logger.infof("Start serving gRPC on port %v ...", grpcPort)
+			if err := ulServer.grpcServer.Serve(grpcListener); err != nil {
+				logger.errorf("Failed to serve gRPC: %v", err)
+			}
+		}()
+	}
+	return ulServer.serve(listener, container, drainTimeout, stop), nil
+}
+
+// serve serves http requests in a go routine until stop is closed, and then
+// shuts down: it drains the requests in flight, and the gRPC calls if any, for
+// up to drainTimeout, stops the watcher and closes the store. The returned channel is closed once it's
+// done.
+func (s *urlLookupServer) serve(listener net.Listener, handler http.Handler, drainTimeout time.Duration,
+	stop <-chan struct{}) <-chan struct{} {
+	httpServer := &http.Server{Handler: handler}
 	go func() {
-		log.Println("Start serving ...")
-		if err = httpServer.Serve(listener); err != nil {
-			log.Printf("Failed to serve request: %v", err)
+		logger.infof("Start serving ...")
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.errorf("Failed to serve request: %v", err)
 		}
 	}()
-	return nil
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-stop
+		logger.infof("Shutting down, draining requests for up to %v", drainTimeout)
+		s.reloadLock.Lock()
+		s.draining = true
+		s.reloadLock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.errorf("Failed to drain requests: %v", err)
+			httpServer.Close()
+		}
+		if s.grpcServer != nil {
+			stopped := make(chan struct{})
+			go func() {
+				s.grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				logger.errorf("Failed to drain gRPC calls: %v", ctx.Err())
+				s.grpcServer.Stop()
+			}
+		}
+		s.stopWatching()
+		if s.apiKeys != nil {
+			s.apiKeys.stopWatching()
+		}
+
+		// No reload is in progress, and none starts anymore
+		s.reloadLock.Lock()
+		defer s.reloadLock.Unlock()
+		if closer, ok := s.store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.errorf("Failed to close the store: %v", err)
+			}
+		}
+		if s.journal != nil {
+			if err := s.journal.Close(); err != nil {
+				logger.errorf("Failed to close the journal: %v", err)
+			}
+		}
+		if s.accessLog != nil {
+			if err := s.accessLog.Close(); err != nil {
+				logger.errorf("Failed to close the access log: %v", err)
+			}
+		}
+		logger.infof("Shut down")
+	}()
+	return done
+}
+
+// newContainer creates the container of the web services
+func (s *urlLookupServer) newContainer() *restful.Container {
+	container := restful.NewContainer()
+	container.Filter(requestIDFilter)
+	container.Filter(s.authFilter)
+	container.Filter(s.rateLimitFilter)
+	container.ServiceErrorHandler(writeServiceError)
+	ws := &restful.WebService{}
+	ws.Produces(restful.MIME_JSON)
+	// The versions of the lookup API, and what they return
+	versions := []struct {
+		view     infoView
+		entity   interface{}
+		entities interface{}
+	}{
+		{infoV1, URLInfoV1{}, []URLInfoV1{}},
+		{func(info *URLInfo) interface{} { return s.infoV2(info) }, URLInfo{}, []URLInfo{}},
+	}
+	for i, v := range versions {
+		version := i + 1
+		ws.Route(ws.
+			GET(fmt.Sprintf("/urlinfo/%v/{%s}/{%s:*}", version, hostNameAndPort, originalPathAndQueryString)).
+			To(s.lookupURL(v.view)).
+			Doc("URL lookup service").
+			Param(ws.PathParameter(hostNameAndPort, "Host name and port as <host>:<port>").DataType("string")).
+			Param(ws.PathParameter(originalPathAndQueryString, "Original path and query string").DataType("string")).
+			Writes(v.entity))
+		ws.Route(ws.
+			POST(fmt.Sprintf("/urlinfo/%v/batch", version)).
+			To(s.lookupURLs(v.view)).
+			Doc("URL lookup service for a batch of URLs").
+			Consumes(restful.MIME_JSON).
+			Reads([]URLQuery{}).
+			Writes(v.entities))
+	}
+	ws.Route(ws.
+		GET("/taxonomy").
+		To(s.getTaxonomy).
+		Doc("Get the taxonomy of the categories").
+		Writes(Taxonomy{}))
+	ws.Route(ws.
+		GET("/cache").
+		To(s.getCache).
+		Doc("Get the URL store statistics").
+		Writes(StoreStats{}))
+	ws.Route(ws.
+		GET("/snapshot").
+		To(s.getSnapshots).
+		Doc("Get the current and previous snapshots of the configuration files").
+		Writes(SnapshotsInfo{}))
+	ws.Route(ws.
+		GET("/healthz").
+		To(s.getHealth).
+		Doc("Check that the server is alive").
+		Writes(HealthInfo{}))
+	ws.Route(ws.
+		GET("/readyz").
+		To(s.getReadiness).
+		Doc("Check that the configuration files have been loaded").
+		Writes(ReadinessInfo{}))
+	ws.Route(ws.
+		GET("/metrics").
+		To(s.getMetrics).
+		Doc("Get the metrics in the Prometheus text format").
+		Produces("text/plain"))
+	container.Add(ws)
+	container.Add(s.newAdminService())
+	if s.extAuthz != nil {
+		container.Add(s.newExtAuthzService())
+	}
+	return container
 }
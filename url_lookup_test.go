@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"path/filepath"
-	"sync"
+	"reflect"
 	"testing"
+	"time"
 )
 
 var entries1 = &URLs{
@@ -14,19 +17,19 @@ var entries1 = &URLs{
 			HostAndPort:  "www.cnn.com:80",
 			OriginalPath: "news",
 			Category:     "news",
-			Safe:         true,
+			Safe:         boolPtr(true),
 		},
 		URLDBEntry{
 			HostAndPort:  "www.terror.com:80",
 			OriginalPath: "bomb-recipes",
 			Category:     "terrorism",
-			Safe:         false,
+			Safe:         boolPtr(false),
 		},
 		URLDBEntry{
 			HostAndPort:  "www.food.com:80",
 			OriginalPath: "recipes",
 			Category:     "food",
-			Safe:         true,
+			Safe:         boolPtr(true),
 		},
 	},
 }
@@ -37,29 +40,39 @@ var entries2 = &URLs{
 			HostAndPort:  "www.espn.com:80",
 			OriginalPath: "programming",
 			Category:     "sports",
-			Safe:         true,
+			Safe:         boolPtr(true),
 		},
 		URLDBEntry{
 			HostAndPort:  "www.fun.com:80",
 			OriginalPath: "movies",
 			Category:     "violence",
-			Safe:         false,
+			Safe:         boolPtr(false),
 		},
 		URLDBEntry{
 			HostAndPort:  "www.furniture.com:80",
 			OriginalPath: "all-styles",
 			Category:     "shopping",
-			Safe:         true,
+			Safe:         boolPtr(true),
 		},
 		URLDBEntry{
 			HostAndPort:  "www.rebellion.com:80",
 			OriginalPath: "strategies",
 			Category:     "violence",
-			Safe:         false,
+			Safe:         boolPtr(false),
 		},
 	},
 }
 
+// Create a bucket store in a new tmp dir, and return the function that removes
+// it
+func newTestBucketStore(t *testing.T, hashTableSize, maxUrlsCached int) (*bucketStore, func()) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Fatalf("Failed to create tmp dir: %v\n", err)
+	}
+	return newBucketStore(urlCachePath, hashTableSize, maxUrlsCached), func() { os.RemoveAll(urlCachePath) }
+}
+
 // Test URL load with two url config files
 func TestLoadUrls(t *testing.T) {
 	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
@@ -87,15 +100,16 @@ func TestLoadUrls(t *testing.T) {
 	if err := ioutil.WriteFile(tmpfn, data, 0666); err != nil {
 		t.Errorf("Failed to write to file %v: %v\n", tmpfn, err)
 	}
-	//defer os.RemoveAll(urlCfgPath)
+	defer os.RemoveAll(urlCfgPath)
 
 	// Create the server
+	store, remove := newTestBucketStore(t, 31, 100)
+	defer remove()
 	server := &urlLookupServer{
 		httpPort:     16888,
 		urlCfgPath:   urlCfgPath,
-		urlCachePath: "",
-		urldb:        make(URLDB),
-		lock:         sync.Mutex{},
+		urlCachePath: store.urlCachePath,
+		store:        store,
 	}
 
 	// Load the URLs
@@ -109,8 +123,13 @@ func TestLoadUrls(t *testing.T) {
 			hostAndPort:  entry.HostAndPort,
 			originalPath: entry.OriginalPath,
 		}
-		info := server.urldb[url]
-		if info.Category != entry.Category || info.Safe != entry.Safe {
+		infos, err := server.store.Get([]URL{url})
+		if err != nil {
+			t.Errorf("Failed to get %v: %v\n", url, err)
+			continue
+		}
+		info := infos[0]
+		if info.Category != entry.Category || info.Safe != *entry.Safe {
 			t.Errorf("Test failed with unmatched record: %v\n", err)
 		}
 	}
@@ -121,9 +140,222 @@ func TestLoadUrls(t *testing.T) {
 			hostAndPort:  entry.HostAndPort,
 			originalPath: entry.OriginalPath,
 		}
-		info := server.urldb[url]
-		if info.Category != entry.Category || info.Safe != entry.Safe {
+		infos, err := server.store.Get([]URL{url})
+		if err != nil {
+			t.Errorf("Failed to get %v: %v\n", url, err)
+			continue
+		}
+		info := infos[0]
+		if info.Category != entry.Category || info.Safe != *entry.Safe {
 			t.Errorf("Test failed with unmatched record: %v\n", err)
 		}
 	}
 }
+
+func writeURLs(t *testing.T, path string, entries ...URLDBEntry) {
+	data, err := json.Marshal(&URLs{URLEntries: entries})
+	if err != nil {
+		t.Errorf("Failed to Marshall: %v\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Errorf("Failed to write to file %v: %v\n", path, err)
+	}
+}
+
+// Look up URLs, and return their categories
+func lookupCategories(server *urlLookupServer, urls ...URL) []string {
+	infos, err := server.lookup(urls)
+	categories := make([]string, len(urls))
+	for i := range categories {
+		if err != nil {
+			categories[i] = err.Error()
+		} else {
+			categories[i] = infos[i].Category
+		}
+	}
+	return categories
+}
+
+// Test that the records removed from a file, or of a removed file, are retracted
+func TestReloadFile(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	news := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)}
+	food := URLDBEntry{HostAndPort: "www.food.com:80", OriginalPath: "recipes", Category: "food", Safe: boolPtr(true)}
+	evil := URLDBEntry{HostAndPort: "*.evil.com:80", OriginalPath: "*", Category: "malware"}
+	files := URLDBEntry{HostAndPort: "www.files.com:80", OriginalPath: "pub", Match: matchPrefix, Category: "files"}
+	cfg1, cfg2 := filepath.Join(urlCfgPath, "urlcfg1.json"), filepath.Join(urlCfgPath, "urlcfg2.json")
+	writeURLs(t, cfg1, news, food, evil, files)
+	writeURLs(t, cfg2, food)
+
+	store, remove := newTestBucketStore(t, 31, 100)
+	defer remove()
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: store}
+	if err := server.loadURLs(); err != nil {
+		t.Errorf("Failed to load url Config: %v\n", err)
+	}
+	urls := []URL{{"www.cnn.com:80", "news"}, {"www.food.com:80", "recipes"}, {"a.evil.com:80", "x"}, {"www.files.com:80", "pub/x"}}
+	if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, []string{"news", "food", "malware", "files"}) {
+		t.Errorf("Unmatched records after loading: %v\n", categories)
+	}
+
+	// Remove the rules, change a URL and add one
+	news.Category = "fake-news"
+	sports := URLDBEntry{HostAndPort: "www.espn.com:80", OriginalPath: "programming", Category: "sports", Safe: boolPtr(true)}
+	writeURLs(t, cfg1, news, food, sports)
+	if err := server.loadFromFile(cfg1); err != nil {
+		t.Errorf("Failed to load %v: %v\n", cfg1, err)
+	}
+	urls = append(urls, URL{"www.espn.com:80", "programming"})
+	expected := []string{"fake-news", "food", "Unknown", "Unknown", "sports"}
+	if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Unmatched records after changing %v: %v, expected %v\n", cfg1, categories, expected)
+	}
+
+	// The URL also in the other file is kept
+	if err := server.unloadFile(cfg1); err != nil {
+		t.Errorf("Failed to unload %v: %v\n", cfg1, err)
+	}
+	expected = []string{"Unknown", "food", "Unknown", "Unknown", "Unknown"}
+	if categories := lookupCategories(server, urls...); !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Unmatched records after removing %v: %v, expected %v\n", cfg1, categories, expected)
+	}
+}
+
+// Test that the watcher loads created and changed files, and unloads removed and
+// renamed ones
+func TestWatchForUpdate(t *testing.T) {
+	urlCfgPath, err := ioutil.TempDir("", "urlcfg")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCfgPath)
+
+	server := &urlLookupServer{urlCfgPath: urlCfgPath, store: newMemStore()}
+	if err := server.watchForUpdate(); err != nil {
+		t.Errorf("Failed to watch %v: %v\n", urlCfgPath, err)
+		return
+	}
+	url := URL{"www.cnn.com:80", "news"}
+	waitFor := func(category string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if categories := lookupCategories(server, url); categories[0] == category {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("%v didn't become %v\n", url, category)
+	}
+
+	entry := URLDBEntry{HostAndPort: "www.cnn.com:80", OriginalPath: "news", Category: "news", Safe: boolPtr(true)}
+	cfg1, cfg2 := filepath.Join(urlCfgPath, "urlcfg1.json"), filepath.Join(urlCfgPath, "urlcfg2.json")
+	writeURLs(t, cfg1, entry)
+	waitFor("news")
+
+	entry.Category = "fake-news"
+	writeURLs(t, cfg1, entry)
+	waitFor("fake-news")
+
+	// A file moved out of the directory is unloaded
+	outside := urlCfgPath + ".moved"
+	if err := os.Rename(cfg1, outside); err != nil {
+		t.Errorf("Failed to rename %v: %v\n", cfg1, err)
+	}
+	defer os.Remove(outside)
+	waitFor("Unknown")
+
+	// A file moved into the directory is loaded
+	if err := os.Rename(outside, cfg2); err != nil {
+		t.Errorf("Failed to rename %v: %v\n", outside, err)
+	}
+	waitFor("fake-news")
+
+	if err := os.Remove(cfg2); err != nil {
+		t.Errorf("Failed to remove %v: %v\n", cfg2, err)
+	}
+	waitFor("Unknown")
+}
+
+// Test that the verdicts are validated, kept by the bucket files, and returned
+// only by the version 2 of the API
+func TestVerdict(t *testing.T) {
+	urlCachePath, err := ioutil.TempDir("", "urlcache")
+	if err != nil {
+		t.Errorf("Failed to create tmp dir: %v\n", err)
+		return
+	}
+	defer os.RemoveAll(urlCachePath)
+
+	invalid := []Verdict{
+		{ThreatType: "spam"},
+		{RiskScore: 101},
+		{FirstSeen: "yesterday"},
+		{FirstSeen: "2020-02-01T00:00:00Z", LastSeen: "2020-01-01T00:00:00Z"},
+	}
+	server := &urlLookupServer{store: newBucketStore(urlCachePath, 3, 1)}
+	for _, verdict := range invalid {
+		entry := &URLDBEntry{HostAndPort: "evil.com", OriginalPath: "login", Category: "phishing", Verdict: verdict}
+		if err := server.addEntry(entry); err == nil {
+			t.Errorf("Added an entry with an invalid verdict %v\n", verdict)
+		}
+	}
+
+	verdict := Verdict{ThreatType: "phishing", RiskScore: 90, Source: "feed1",
+		FirstSeen: "2020-01-01T01:00:00+01:00", LastSeen: "2020-02-01T00:00:00Z"}
+	entries := []URLDBEntry{
+		{HostAndPort: "evil.com", OriginalPath: "login", Category: "phishing", Verdict: verdict},
+		{HostAndPort: "www.cnn.com", OriginalPath: "news", Category: "news", Safe: boolPtr(true)},
+	}
+	for i := range entries {
+		if err := server.addEntry(&entries[i]); err != nil {
+			t.Errorf("Failed to add %v: %v\n", entries[i], err)
+		}
+	}
+
+	// The URL is read back from its bucket file, as the cache has room for one URL
+	expected := Verdict{ThreatType: "phishing", RiskScore: 90, Source: "feed1",
+		FirstSeen: "2020-01-01T00:00:00Z", LastSeen: "2020-02-01T00:00:00Z"}
+	for _, path := range []string{"/urlinfo/1/www.cnn.com/news", "/urlinfo/2/www.cnn.com/news"} {
+		serveRequest(server, "GET", path, "", "", &URLInfo{})
+	}
+	info := &URLInfo{}
+	serveRequest(server, "GET", "/urlinfo/2/evil.com/login", "", "", info)
+	if !info.Found || info.Verdict != expected {
+		t.Errorf("Looked up %v with version 2, expected %v\n", *info, expected)
+	}
+	if stats := server.store.Stats(); stats.Misses == 0 {
+		t.Errorf("No URL was read from the bucket files: %v\n", *stats)
+	}
+
+	// The version 1 returns the json it always has
+	for path, expected := range map[string]string{
+		"/urlinfo/1/evil.com/login":    `{"category":"phishing","safe":false}`,
+		"/urlinfo/1/www.cnn.com/news":  `{"category":"news","safe":true}`,
+		"/urlinfo/1/www.cnn.com/sport": `{"category":"Unknown","safe":false}`,
+	} {
+		var body json.RawMessage
+		serveRequest(server, "GET", path, "", "", &body)
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, body); err != nil || compact.String() != expected {
+			t.Errorf("Looked up %v with version 1: %s, expected %v\n", path, body, expected)
+		}
+	}
+	var infos []URLInfo
+	serveRequest(server, "POST", "/urlinfo/2/batch", `[{"url": "http://evil.com/login"}]`, "", &infos)
+	if len(infos) != 1 || infos[0].Verdict != expected {
+		t.Errorf("Looked up %v in a batch with version 2, expected %v\n", infos, expected)
+	}
+	infos = nil
+	serveRequest(server, "POST", "/urlinfo/1/batch", `[{"url": "http://evil.com/login"}]`, "", &infos)
+	if len(infos) != 1 || infos[0].Verdict != (Verdict{}) {
+		t.Errorf("Looked up %v in a batch with version 1\n", infos)
+	}
+}
@@ -0,0 +1,235 @@
+// The gRPC API of the URL lookup service. It looks up URLs in the same cache as
+// the version 2 of the REST API, /urlinfo/2/...
+//
+// The Go code is generated with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//     urllookuppb/url_lookup.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: urllookuppb/url_lookup.proto
+
+package urllookuppb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	URLLookup_Lookup_FullMethodName       = "/urllookup.v1.URLLookup/Lookup"
+	URLLookup_BatchLookup_FullMethodName  = "/urllookup.v1.URLLookup/BatchLookup"
+	URLLookup_LookupStream_FullMethodName = "/urllookup.v1.URLLookup/LookupStream"
+)
+
+// URLLookupClient is the client API for URLLookup service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type URLLookupClient interface {
+	// Lookup looks up a URL
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// BatchLookup looks up up to 1000 URLs at once
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error)
+	// LookupStream looks up the URL of every request on the stream, and
+	// responds to them in order. An invalid URL is answered with an error
+	// rather than ending the stream.
+	LookupStream(ctx context.Context, opts ...grpc.CallOption) (URLLookup_LookupStreamClient, error)
+}
+
+type uRLLookupClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewURLLookupClient(cc grpc.ClientConnInterface) URLLookupClient {
+	return &uRLLookupClient{cc}
+}
+
+func (c *uRLLookupClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, URLLookup_Lookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLLookupClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error) {
+	out := new(BatchLookupResponse)
+	err := c.cc.Invoke(ctx, URLLookup_BatchLookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLLookupClient) LookupStream(ctx context.Context, opts ...grpc.CallOption) (URLLookup_LookupStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &URLLookup_ServiceDesc.Streams[0], URLLookup_LookupStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &uRLLookupLookupStreamClient{stream}
+	return x, nil
+}
+
+type URLLookup_LookupStreamClient interface {
+	Send(*LookupRequest) error
+	Recv() (*LookupResponse, error)
+	grpc.ClientStream
+}
+
+type uRLLookupLookupStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *uRLLookupLookupStreamClient) Send(m *LookupRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *uRLLookupLookupStreamClient) Recv() (*LookupResponse, error) {
+	m := new(LookupResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// URLLookupServer is the server API for URLLookup service.
+// All implementations must embed UnimplementedURLLookupServer
+// for forward compatibility
+type URLLookupServer interface {
+	// Lookup looks up a URL
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// BatchLookup looks up up to 1000 URLs at once
+	BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error)
+	// LookupStream looks up the URL of every request on the stream, and
+	// responds to them in order. An invalid URL is answered with an error
+	// rather than ending the stream.
+	LookupStream(URLLookup_LookupStreamServer) error
+	mustEmbedUnimplementedURLLookupServer()
+}
+
+// UnimplementedURLLookupServer must be embedded to have forward compatible implementations.
+type UnimplementedURLLookupServer struct {
+}
+
+func (UnimplementedURLLookupServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedURLLookupServer) BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedURLLookupServer) LookupStream(URLLookup_LookupStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method LookupStream not implemented")
+}
+func (UnimplementedURLLookupServer) mustEmbedUnimplementedURLLookupServer() {}
+
+// UnsafeURLLookupServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to URLLookupServer will
+// result in compilation errors.
+type UnsafeURLLookupServer interface {
+	mustEmbedUnimplementedURLLookupServer()
+}
+
+func RegisterURLLookupServer(s grpc.ServiceRegistrar, srv URLLookupServer) {
+	s.RegisterService(&URLLookup_ServiceDesc, srv)
+}
+
+func _URLLookup_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLLookupServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLLookup_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLLookupServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLLookup_BatchLookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchLookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLLookupServer).BatchLookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLLookup_BatchLookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLLookupServer).BatchLookup(ctx, req.(*BatchLookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLLookup_LookupStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(URLLookupServer).LookupStream(&uRLLookupLookupStreamServer{stream})
+}
+
+type URLLookup_LookupStreamServer interface {
+	Send(*LookupResponse) error
+	Recv() (*LookupRequest, error)
+	grpc.ServerStream
+}
+
+type uRLLookupLookupStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *uRLLookupLookupStreamServer) Send(m *LookupResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *uRLLookupLookupStreamServer) Recv() (*LookupRequest, error) {
+	m := new(LookupRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// URLLookup_ServiceDesc is the grpc.ServiceDesc for URLLookup service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var URLLookup_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "urllookup.v1.URLLookup",
+	HandlerType: (*URLLookupServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _URLLookup_Lookup_Handler,
+		},
+		{
+			MethodName: "BatchLookup",
+			Handler:    _URLLookup_BatchLookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "LookupStream",
+			Handler:       _URLLookup_LookupStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "urllookuppb/url_lookup.proto",
+}